@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kinds of the cluster scoped admin policies, applying before and after the network policies
+const (
+	KindAdminNetworkPolicy         = "AdminNetworkPolicy"
+	KindBaselineAdminNetworkPolicy = "BaselineAdminNetworkPolicy"
+)
+
+// adminNetworkPolicyGroup is the API group of the admin policies
+var adminNetworkPolicyGroup = schema.GroupVersion{Group: "policy.networking.k8s.io", Version: "v1alpha1"}
+
+// adminNetworkPolicyTiers maps the kinds of the admin policies to the tier of their rules
+var adminNetworkPolicyTiers = map[string]string{
+	KindAdminNetworkPolicy:         TierAdmin,
+	KindBaselineAdminNetworkPolicy: TierBaseline,
+}
+
+// maxPortRange is the largest port range expanded to its ports, larger ranges are kept as protocol/start-end ranges
+const maxPortRange = 1024
+
+// AdminNetworkPolicy is an AdminNetworkPolicy or the BaselineAdminNetworkPolicy, which has no priority
+// the rules of the subject pods apply in order, the first one matching the peer and port decides
+type AdminNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              AdminNetworkPolicySpec `json:"spec"`
+}
+
+// AdminNetworkPolicySpec holds the subject and the ordered rules, a lower priority applies first
+type AdminNetworkPolicySpec struct {
+	Priority int32                     `json:"priority,omitempty"`
+	Subject  AdminNetworkPolicySubject `json:"subject"`
+	Ingress  []AdminNetworkPolicyRule  `json:"ingress,omitempty"`
+	Egress   []AdminNetworkPolicyRule  `json:"egress,omitempty"`
+}
+
+// AdminNetworkPolicySubject selects the pods of the namespaces matching Namespaces, or the Pods
+type AdminNetworkPolicySubject struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPods       `json:"pods,omitempty"`
+}
+
+// NamespacedPods selects the pods matching PodSelector in the namespaces matching NamespaceSelector
+type NamespacedPods struct {
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+	PodSelector       metav1.LabelSelector `json:"podSelector"`
+}
+
+// AdminNetworkPolicyRule allows, denies or passes to the network policies the traffic from the From peers
+// for ingress rules, or to the To peers for egress rules
+type AdminNetworkPolicyRule struct {
+	Name   string                   `json:"name,omitempty"`
+	Action string                   `json:"action"`
+	From   []AdminNetworkPolicyPeer `json:"from,omitempty"`
+	To     []AdminNetworkPolicyPeer `json:"to,omitempty"`
+	Ports  []AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// AdminNetworkPolicyPeer is the pods of namespaces, pods, or for egress nodes and networks
+type AdminNetworkPolicyPeer struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPods       `json:"pods,omitempty"`
+	Nodes      *metav1.LabelSelector `json:"nodes,omitempty"`
+	Networks   []string              `json:"networks,omitempty"`
+}
+
+// AdminNetworkPolicyPort is a port number, a named port or a port range
+type AdminNetworkPolicyPort struct {
+	PortNumber *AdminNetworkPolicyPortNumber `json:"portNumber,omitempty"`
+	NamedPort  *string                       `json:"namedPort,omitempty"`
+	PortRange  *AdminNetworkPolicyPortRange  `json:"portRange,omitempty"`
+}
+
+// AdminNetworkPolicyPortNumber is a port of a protocol
+type AdminNetworkPolicyPortNumber struct {
+	Protocol corev1.Protocol `json:"protocol"`
+	Port     int32           `json:"port"`
+}
+
+// AdminNetworkPolicyPortRange is the ports from Start to End included
+type AdminNetworkPolicyPortRange struct {
+	Protocol corev1.Protocol `json:"protocol"`
+	Start    int32           `json:"start"`
+	End      int32           `json:"end"`
+}
+
+// LoadAdminNetworkPolicies lists the AdminNetworkPolicies and BaselineAdminNetworkPolicies through the
+// controller-runtime client, the kinds the cluster does not serve are skipped
+func LoadAdminNetworkPolicies(c client.Client) ([]AdminNetworkPolicy, error) {
+	var adminPolicies []AdminNetworkPolicy
+	for _, kind := range []string{KindAdminNetworkPolicy, KindBaselineAdminNetworkPolicy} {
+		list := new(unstructured.UnstructuredList)
+		list.SetGroupVersionKind(adminNetworkPolicyGroup.WithKind(kind + "List"))
+		if err := c.List(context.Background(), list); err != nil {
+			if meta.IsNoMatchError(err) || apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, item := range list.Items {
+			var policy AdminNetworkPolicy
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &policy); err != nil {
+				return nil, fmt.Errorf("%s %s: %v", kind, item.GetName(), err)
+			}
+			policy.Kind = kind
+			adminPolicies = append(adminPolicies, policy)
+		}
+	}
+	return adminPolicies, nil
+}
+
+// AdminNetworkPolicyTranslator translates an admin policy into a cluster scoped FirewallPolicy whose rules carry
+// the tier and priority of the policy, one rule per peer in the order of the policy
+// rules to nodes are left out
+func (t *Translator) AdminNetworkPolicyTranslator(policy AdminNetworkPolicy) {
+	t.policy = &FirewallPolicy{Name: policy.Name, Kind: policy.Kind}
+	subject := adminSubjectLocation(policy.Spec.Subject)
+
+	for i, ingress := range policy.Spec.Ingress {
+		action, ports, ranges, ok := adminRule(policy, "ingress", i, ingress)
+		if !ok {
+			continue
+		}
+		for _, peer := range adminPeerLocations(policy, "ingress", i, ingress.From) {
+			t.appendRule(adminPortsLocation(peer, ports, ranges), subject, action, netv1.PolicyTypeIngress)
+		}
+	}
+	for i, egress := range policy.Spec.Egress {
+		action, ports, ranges, ok := adminRule(policy, "egress", i, egress)
+		if !ok {
+			continue
+		}
+		for _, peer := range adminPeerLocations(policy, "egress", i, egress.To) {
+			t.appendRule(subject, adminPortsLocation(peer, ports, ranges), action, netv1.PolicyTypeEgress)
+		}
+	}
+
+	for i := range t.policy.Rules {
+		t.policy.Rules[i].Tier = adminNetworkPolicyTiers[policy.Kind]
+		t.policy.Rules[i].Priority = int(policy.Spec.Priority)
+	}
+	t.Policies = append(t.Policies, *t.policy)
+}
+
+// adminRule returns the action, the ports and the port ranges too large to expand of a rule,
+// false when the rule cannot be translated
+func adminRule(policy AdminNetworkPolicy, direction string, index int, rule AdminNetworkPolicyRule) (string, []netv1.NetworkPolicyPort, []string, bool) {
+	skip := func(reason string) {
+		println("skipped", policy.Kind, policy.Name, direction, "rule", index, ":", reason)
+	}
+
+	var action string
+	switch rule.Action {
+	case "Allow":
+		action = ActionAllow
+	case "Deny":
+		action = ActionDeny
+	case "Pass":
+		action = ActionPass
+	default:
+		skip("unknown action " + rule.Action)
+		return "", nil, nil, false
+	}
+
+	var ports []netv1.NetworkPolicyPort
+	var ranges []string
+	for _, port := range rule.Ports {
+		switch {
+		case port.PortNumber != nil:
+			ports = append(ports, adminPort(port.PortNumber.Protocol, intstr.FromInt(int(port.PortNumber.Port))))
+		case port.NamedPort != nil:
+			// named ports are resolved like the named ports of network policies, on TCP
+			ports = append(ports, adminPort(corev1.ProtocolTCP, intstr.FromString(*port.NamedPort)))
+		case port.PortRange != nil:
+			if port.PortRange.End < port.PortRange.Start {
+				skip(fmt.Sprintf("port range %d-%d ends before it starts", port.PortRange.Start, port.PortRange.End))
+				return "", nil, nil, false
+			}
+			if port.PortRange.End-port.PortRange.Start >= maxPortRange {
+				protocol := port.PortRange.Protocol
+				if protocol == "" {
+					protocol = corev1.ProtocolTCP
+				}
+				ranges = append(ranges, portRangeKey(string(protocol), int(port.PortRange.Start), int(port.PortRange.End)))
+				continue
+			}
+			for number := port.PortRange.Start; number <= port.PortRange.End; number++ {
+				ports = append(ports, adminPort(port.PortRange.Protocol, intstr.FromInt(int(number))))
+			}
+		}
+	}
+	return action, ports, ranges, true
+}
+
+// adminPortsLocation returns a location restricted to ports and to port ranges, all ports when there are none
+func adminPortsLocation(location FirewallLocation, ports []netv1.NetworkPolicyPort, ranges []string) FirewallLocation {
+	location = portsLocation(location, ports)
+	location.PortRanges = ranges
+	location.AllPorts = len(ports) == 0 && len(ranges) == 0
+	return location
+}
+
+// adminPort returns a network policy port, TCP when no protocol is given
+func adminPort(protocol corev1.Protocol, port intstr.IntOrString) netv1.NetworkPolicyPort {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	return netv1.NetworkPolicyPort{Protocol: &protocol, Port: &port}
+}
+
+// adminSubjectLocation returns the location of the pods an admin policy applies to
+func adminSubjectLocation(subject AdminNetworkPolicySubject) FirewallLocation {
+	if subject.Pods != nil {
+		return selectorLocation(&subject.Pods.PodSelector, &subject.Pods.NamespaceSelector, "")
+	}
+	namespaces := subject.Namespaces
+	if namespaces == nil {
+		namespaces = &metav1.LabelSelector{}
+	}
+	return selectorLocation(&metav1.LabelSelector{}, namespaces, "")
+}
+
+// adminPeerLocations returns the locations of the peers of a rule, nodes are left out as they are not resolved
+func adminPeerLocations(policy AdminNetworkPolicy, direction string, index int, peers []AdminNetworkPolicyPeer) []FirewallLocation {
+	var locations []FirewallLocation
+	for _, peer := range peers {
+		switch {
+		case peer.Namespaces != nil:
+			locations = append(locations, selectorLocation(&metav1.LabelSelector{}, peer.Namespaces, ""))
+		case peer.Pods != nil:
+			locations = append(locations, selectorLocation(&peer.Pods.PodSelector, &peer.Pods.NamespaceSelector, ""))
+		case len(peer.Networks) > 0:
+			for _, network := range peer.Networks {
+				locations = append(locations, FirewallLocation{CIDR: network})
+			}
+		case peer.Nodes != nil:
+			println("skipped", policy.Kind, policy.Name, direction, "rule", index, "peer : node selectors are not supported")
+		}
+	}
+	return locations
+}
+
+// TranslateAdminNetworkPolicies translates admin policies after the policies already translated,
+// the AdminNetworkPolicies by priority then the BaselineAdminNetworkPolicy
+func (t *Translator) TranslateAdminNetworkPolicies(adminPolicies []AdminNetworkPolicy) []FirewallPolicy {
+	sorted := append([]AdminNetworkPolicy(nil), adminPolicies...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Kind != sorted[j].Kind {
+			return sorted[i].Kind == KindAdminNetworkPolicy
+		}
+		if sorted[i].Spec.Priority != sorted[j].Spec.Priority {
+			return sorted[i].Spec.Priority < sorted[j].Spec.Priority
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	for _, policy := range sorted {
+		t.AdminNetworkPolicyTranslator(policy)
+	}
+	return t.Policies
+}
+
+// tierLess orders the rules of a tier as they apply, by priority then in translation order
+func tierLess(a FirewallRule, b FirewallRule) bool {
+	if a.Priority != b.Priority {
+		return a.Priority < b.Priority
+	}
+	return a.Order < b.Order
+}
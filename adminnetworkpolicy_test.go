@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestAdminNetworkPolicyPortRange checks a port range too large to expand keeps its rule and decides its ports
+func TestAdminNetworkPolicyPortRange(t *testing.T) {
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", "port-range.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	translated := NewTranslator(0).TranslateAdminNetworkPolicies(manifests.AdminPolicies)
+	if got := len(translated[0].Rules); got != 3 {
+		t.Fatalf("got %d rules, want 3", got)
+	}
+	if got := FormatPorts(translated[0].Rules[1].From); got != "TCP/1024-65535" {
+		t.Errorf("got deny rule ports %s, want TCP/1024-65535", got)
+	}
+
+	source := manifests.Inventory.podEndpoint(manifests.Inventory.Pods[1])
+	destination := manifests.Inventory.podEndpoint(manifests.Inventory.Pods[0])
+	allowed := EvaluateConnection(translated, source, destination).Allowed()
+	for port, want := range map[string]bool{"TCP/80": true, "TCP/1024": false, "TCP/8080": false, "TCP/9090": true, "TCP/65535": false, "UDP/8080": true} {
+		if got := allowed.Contains(port); got != want {
+			t.Errorf("%s to %s on %s: got allowed %t, want %t", source.Name, destination.Name, port, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Severities of the findings, from the most to the least urgent
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// severityRank orders the severities
+var severityRank = map[string]int{
+	SeverityHigh:   0,
+	SeverityMedium: 1,
+	SeverityLow:    2,
+}
+
+// Finding is a risk or a problem found in a rule, or in a whole policy when Order is -1
+type Finding struct {
+	Severity  string           `json:"severity" header:"Severity"`
+	Check     string           `json:"check" header:"Check"`
+	Policy    string           `json:"policy" header:"Policy"`
+	Order     int              `json:"order" header:"Order,text"`
+	Direction netv1.PolicyType `json:"direction,omitempty" header:"Direction"`
+	Reason    string           `json:"reason" header:"Reason"`
+}
+
+// newFinding returns a finding on a rule
+func newFinding(severity string, check string, rule FirewallRule, reason string) Finding {
+	return Finding{Severity: severity, Check: check, Policy: rule.Policy.String(), Order: rule.Order, Direction: rule.Direction, Reason: reason}
+}
+
+// sortFindings orders findings by severity, policy and rule
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Severity != b.Severity {
+			return severityRank[a.Severity] < severityRank[b.Severity]
+		}
+		if a.Policy != b.Policy {
+			return a.Policy < b.Policy
+		}
+		return a.Order < b.Order
+	})
+}
+
+// PrintFindings writes findings as JSON, a table or CSV
+func PrintFindings(w io.Writer, findings []Finding, format string) error {
+	switch format {
+	case "json":
+		if findings == nil {
+			findings = []Finding{}
+		}
+		return json.NewEncoder(w).Encode(findings)
+	case "table":
+		newTablePrinter(w).Print(findings)
+		return nil
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"severity", "check", "policy", "order", "direction", "reason"}); err != nil {
+			return err
+		}
+		for _, f := range findings {
+			if err := writer.Write([]string{f.Severity, f.Check, f.Policy, strconv.Itoa(f.Order), string(f.Direction), f.Reason}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unknown findings format %q, use json, table or csv", format)
+}
+
+// Checks of the permissive rules analysis
+const (
+	CheckWorldCIDR          = "world-cidr"
+	CheckAnyPeer            = "any-peer"
+	CheckAllNamespaces      = "all-namespaces"
+	CheckSensitiveAllPorts  = "sensitive-all-ports"
+	CheckRestrictedInternet = "restricted-internet-egress"
+)
+
+// nonInternetCIDRs are the private, shared, loopback and link local ranges, never routed on the internet
+var nonInternetCIDRs = []string{
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"fc00::/7", "fe80::/10", "::1/128",
+}
+
+// PermissiveOptions selects the pods holding sensitive data and the namespaces whose egress is restricted
+type PermissiveOptions struct {
+	Sensitive  labels.Selector
+	Restricted labels.Selector
+}
+
+// AnalyzePermissive flags the allow rules opening too much: from or to the whole internet, from any peer,
+// from all namespaces, to sensitive pods on all ports and to the internet from restricted namespaces
+func AnalyzePermissive(policies []FirewallPolicy, inventory *Inventory, options PermissiveOptions) []Finding {
+	var findings []Finding
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			if rule.Action != ActionAllow || egressFirewallKinds[rule.Policy.Kind] {
+				continue
+			}
+			peer := rule.From
+			if rule.Direction == netv1.PolicyTypeEgress {
+				peer = rule.To
+			}
+
+			switch {
+			case peer.CIDR != "" && isWorldCIDR(peer.CIDR):
+				severity, reason := SeverityHigh, fmt.Sprintf("allows %s from every address", FormatPorts(*rule.PortsLocation()))
+				if rule.Direction == netv1.PolicyTypeEgress {
+					severity, reason = SeverityMedium, fmt.Sprintf("allows %s to every address", FormatPorts(*rule.PortsLocation()))
+				}
+				if len(peer.Except) > 0 {
+					reason += " except " + strings.Join(peer.Except, ",")
+				}
+				findings = append(findings, newFinding(severity, CheckWorldCIDR, rule, fmt.Sprintf("%s %s", peer.CIDR, reason)))
+			case peer.Any:
+				severity, reason := SeverityHigh, fmt.Sprintf("no peer, allows %s from every pod and address", FormatPorts(*rule.PortsLocation()))
+				if rule.Direction == netv1.PolicyTypeEgress {
+					severity, reason = SeverityMedium, fmt.Sprintf("no peer, allows %s to every pod and address", FormatPorts(*rule.PortsLocation()))
+				}
+				findings = append(findings, newFinding(severity, CheckAnyPeer, rule, reason))
+			case peer.AllNamespaces:
+				findings = append(findings, newFinding(SeverityMedium, CheckAllNamespaces, rule,
+					fmt.Sprintf("empty namespaceSelector, allows %s with pods %s of every namespace", FormatPorts(*rule.PortsLocation()), formatSelector(peer.PodSelector))))
+			}
+
+			if rule.Direction == netv1.PolicyTypeIngress && options.Sensitive != nil && !options.Sensitive.Empty() && rulePorts(rule).All {
+				if pods := sensitivePods(rule.To, inventory, options.Sensitive); len(pods) > 0 {
+					findings = append(findings, newFinding(SeverityHigh, CheckSensitiveAllPorts, rule,
+						fmt.Sprintf("allows all ports from %s to sensitive pods %s", FormatLocation(rule.From), strings.Join(pods, ","))))
+				}
+			}
+
+			if rule.Direction == netv1.PolicyTypeEgress && options.Restricted != nil && !options.Restricted.Empty() && reachesInternet(rule.To) {
+				if options.Restricted.Matches(namespaceLabels(inventory, rule.Policy.Namespace)) {
+					findings = append(findings, newFinding(SeverityHigh, CheckRestrictedInternet, rule,
+						fmt.Sprintf("restricted namespace %s allows %s to the internet through %s", rule.Policy.Namespace, FormatPorts(*rule.PortsLocation()), FormatLocation(rule.To))))
+				}
+			}
+		}
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// isWorldCIDR reports if a CIDR holds every address of its family
+func isWorldCIDR(cidr string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, _ := network.Mask.Size()
+	return ones == 0
+}
+
+// reachesInternet reports if a location holds internet addresses, any address or a CIDR not inside private ranges
+func reachesInternet(location FirewallLocation) bool {
+	if location.Any || location.DNSName != "" {
+		return true
+	}
+	if location.CIDR == "" {
+		return false
+	}
+	_, network, err := net.ParseCIDR(location.CIDR)
+	if err != nil {
+		return false
+	}
+	ones, _ := network.Mask.Size()
+	for _, private := range nonInternetCIDRs {
+		_, privateNetwork, _ := net.ParseCIDR(private)
+		privateOnes, _ := privateNetwork.Mask.Size()
+		if privateNetwork.Contains(network.IP) && privateOnes <= ones {
+			return false
+		}
+	}
+	return true
+}
+
+// sensitivePods returns the sensitive pods of a location, from the inventory or from the labels of its pod selector
+func sensitivePods(location FirewallLocation, inventory *Inventory, sensitive labels.Selector) []string {
+	var pods []string
+	if inventory != nil && len(inventory.Pods) > 0 {
+		for _, pod := range inventory.NetworkPods() {
+			endpoint := inventory.podEndpoint(pod)
+			if sensitive.Matches(endpoint.Labels) && location.matchesEndpoint(endpoint) {
+				pods = append(pods, pod.Namespace+"/"+pod.Name)
+			}
+		}
+		return pods
+	}
+
+	if location.PodSelector != nil && sensitive.Matches(labels.Set(location.PodSelector.MatchLabels)) {
+		pods = append(pods, FormatLocation(location))
+	}
+	return pods
+}
+
+// namespaceLabels returns the labels of a namespace, only its name label without inventory
+func namespaceLabels(inventory *Inventory, namespace string) labels.Set {
+	if inventory == nil {
+		return labels.Set{namespaceNameLabel: namespace}
+	}
+	return inventory.NamespaceLabels(namespace)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// findingLine gives the part of a finding the analysis tests check
+func findingLine(finding Finding) string {
+	return fmt.Sprintf("%s %s %s %d", finding.Severity, finding.Check, finding.Policy, finding.Order)
+}
+
+func TestAnalyzePermissive(t *testing.T) {
+	db := metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+	api := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}
+	postgres := intstr.FromInt(5432)
+	ports := []netv1.NetworkPolicyPort{{Port: &postgres}}
+	block := func(cidr string, except ...string) []netv1.NetworkPolicyPeer {
+		return []netv1.NetworkPolicyPeer{{IPBlock: &netv1.IPBlock{CIDR: cidr, Except: except}}}
+	}
+	inventory := &Inventory{Namespaces: []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: map[string]string{"egress": "restricted"}}},
+	}}
+
+	tests := []struct {
+		name       string
+		ingress    []netv1.NetworkPolicyIngressRule
+		egress     []netv1.NetworkPolicyEgressRule
+		inventory  *Inventory
+		sensitive  string
+		restricted string
+		want       []string
+	}{
+		{
+			name:    "ingress from every address",
+			ingress: []netv1.NetworkPolicyIngressRule{{From: block("0.0.0.0/0"), Ports: ports}},
+			want:    []string{"high world-cidr ns/db 0"},
+		},
+		{
+			name:   "egress to every IPv6 address, after the reject of its except block",
+			egress: []netv1.NetworkPolicyEgressRule{{To: block("::/0", "fd00::/8"), Ports: ports}},
+			want:   []string{"medium world-cidr ns/db 2"},
+		},
+		{
+			name:    "private range is not the world",
+			ingress: []netv1.NetworkPolicyIngressRule{{From: block("10.0.0.0/8"), Ports: ports}},
+		},
+		{
+			name:    "ingress without peer",
+			ingress: []netv1.NetworkPolicyIngressRule{{Ports: ports}},
+			want:    []string{"high any-peer ns/db 0"},
+		},
+		{
+			name:    "empty namespace selector",
+			ingress: []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{PodSelector: api, NamespaceSelector: &metav1.LabelSelector{}}}, Ports: ports}},
+			want:    []string{"medium all-namespaces ns/db 0"},
+		},
+		{
+			name:      "sensitive pods on all ports",
+			ingress:   []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{PodSelector: api}}}},
+			sensitive: "app=db",
+			want:      []string{"high sensitive-all-ports ns/db 0"},
+		},
+		{
+			name:      "sensitive pods on listed ports",
+			ingress:   []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{PodSelector: api}}, Ports: ports}},
+			sensitive: "app=db",
+		},
+		{
+			name:      "pods outside the sensitive selector",
+			ingress:   []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{PodSelector: api}}}},
+			sensitive: "app=vault",
+		},
+		{
+			name:       "restricted namespace to the internet",
+			egress:     []netv1.NetworkPolicyEgressRule{{To: block("8.8.8.0/24"), Ports: ports}},
+			inventory:  inventory,
+			restricted: "egress=restricted",
+			want:       []string{"high restricted-internet-egress ns/db 1"},
+		},
+		{
+			name:       "restricted namespace to a private range",
+			egress:     []netv1.NetworkPolicyEgressRule{{To: block("172.16.0.0/16"), Ports: ports}},
+			inventory:  inventory,
+			restricted: "egress=restricted",
+		},
+		{
+			name:       "restricted by name without inventory",
+			egress:     []netv1.NetworkPolicyEgressRule{{To: block("8.8.8.0/24"), Ports: ports}},
+			restricted: "kubernetes.io/metadata.name=ns",
+			want:       []string{"high restricted-internet-egress ns/db 1"},
+		},
+		{
+			name:       "namespace outside the restricted selector",
+			egress:     []netv1.NetworkPolicyEgressRule{{To: block("8.8.8.0/24"), Ports: ports}},
+			restricted: "egress=restricted",
+		},
+		{
+			name:       "findings sorted by severity",
+			ingress:    []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}}, Ports: ports}},
+			egress:     []netv1.NetworkPolicyEgressRule{{}},
+			restricted: "kubernetes.io/metadata.name=ns",
+			want:       []string{"high restricted-internet-egress ns/db 2", "medium all-namespaces ns/db 0", "medium any-peer ns/db 2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := netv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db"},
+				Spec: netv1.NetworkPolicySpec{
+					PodSelector: db,
+					PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress},
+					Ingress:     test.ingress,
+					Egress:      test.egress,
+				},
+			}
+			var options PermissiveOptions
+			var err error
+			if options.Sensitive, err = labels.Parse(test.sensitive); err != nil {
+				t.Fatal(err)
+			}
+			if options.Restricted, err = labels.Parse(test.restricted); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, finding := range AnalyzePermissive(TranslatePolicies([]netv1.NetworkPolicy{policy}), test.inventory, options) {
+				got = append(got, findingLine(finding))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got findings\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
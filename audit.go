@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	netv1 "k8s.io/api/networking/v1"
+)
+
+// defaultExcludedNamespaces matches the OpenShift and Kubernetes system namespaces
+const defaultExcludedNamespaces = "^(openshift|kube)(-|$)"
+
+// Directions a pod can be left unprotected in
+const (
+	UnprotectedIngress = "ingress"
+	UnprotectedEgress  = "egress"
+	UnprotectedBoth    = "ingress,egress"
+)
+
+// UnprotectedPod is a pod, with the workload owning it, that no policy selects for ingress, egress or both
+type UnprotectedPod struct {
+	Namespace   string `json:"namespace" header:"Namespace"`
+	Pod         string `json:"pod" header:"Pod"`
+	Workload    string `json:"workload" header:"Workload"`
+	Unprotected string `json:"unprotected" header:"Unprotected"`
+}
+
+// UnprotectedNamespace groups the unprotected pods of a namespace
+type UnprotectedNamespace struct {
+	Namespace string           `json:"namespace"`
+	Pods      []UnprotectedPod `json:"pods"`
+}
+
+// AuditUnprotected returns the pods of the inventory no policy selects for ingress, egress or both, grouped by namespace
+// the namespaces matching exclude are left out
+func AuditUnprotected(policies []FirewallPolicy, inventory *Inventory, exclude *regexp.Regexp) []UnprotectedNamespace {
+	var namespaces []UnprotectedNamespace
+	index := map[string]int{}
+	for _, pod := range inventory.NetworkPods() {
+		if exclude != nil && exclude.MatchString(pod.Namespace) {
+			continue
+		}
+
+		endpoint := inventory.podEndpoint(pod)
+		ingress := EvaluateDirection(policies, netv1.PolicyTypeIngress, endpoint, Endpoint{}).Isolated
+		egress := EvaluateDirection(policies, netv1.PolicyTypeEgress, endpoint, Endpoint{}).Isolated
+		var unprotected string
+		switch {
+		case !ingress && !egress:
+			unprotected = UnprotectedBoth
+		case !ingress:
+			unprotected = UnprotectedIngress
+		case !egress:
+			unprotected = UnprotectedEgress
+		default:
+			continue
+		}
+
+		if _, ok := index[pod.Namespace]; !ok {
+			index[pod.Namespace] = len(namespaces)
+			namespaces = append(namespaces, UnprotectedNamespace{Namespace: pod.Namespace})
+		}
+		group := &namespaces[index[pod.Namespace]]
+		group.Pods = append(group.Pods, UnprotectedPod{
+			Namespace:   pod.Namespace,
+			Pod:         pod.Name,
+			Workload:    workloadName(inventory, pod),
+			Unprotected: unprotected,
+		})
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Namespace < namespaces[j].Namespace
+	})
+	for _, namespace := range namespaces {
+		sort.Slice(namespace.Pods, func(i, j int) bool {
+			return namespace.Pods[i].Pod < namespace.Pods[j].Pod
+		})
+	}
+	return namespaces
+}
+
+// PrintUnprotected writes the unprotected pods as JSON grouped by namespace, or one pod per line as a table or CSV
+func PrintUnprotected(w io.Writer, namespaces []UnprotectedNamespace, format string) error {
+	var pods []UnprotectedPod
+	for _, namespace := range namespaces {
+		pods = append(pods, namespace.Pods...)
+	}
+
+	switch format {
+	case "json":
+		if namespaces == nil {
+			namespaces = []UnprotectedNamespace{}
+		}
+		return json.NewEncoder(w).Encode(namespaces)
+	case "table":
+		newTablePrinter(w).Print(pods)
+		return nil
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"namespace", "pod", "workload", "unprotected"}); err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			if err := writer.Write([]string{pod.Namespace, pod.Pod, pod.Workload, pod.Unprotected}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unknown unprotected pods format %q, use json, table or csv", format)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAuditUnprotected(t *testing.T) {
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", "v.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, namespace := range []string{"openshift-dns", "kube-system", "kube", "kubevirt", "openshiftish"} {
+		manifests.Inventory.Pods = append(manifests.Inventory.Pods, corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "pod-0"}})
+	}
+	policies := TranslatePolicies(manifests.Policies)
+
+	tests := []struct {
+		name    string
+		exclude string
+		want    []string
+	}{
+		{
+			// db/pg-0 is isolated both ways and shop/web-0 for ingress only
+			name: "no exclusion",
+			want: []string{
+				"edge/lb-1 ingress,egress",
+				"kube/pod-0 ingress,egress",
+				"kube-system/pod-0 ingress,egress",
+				"kubevirt/pod-0 ingress,egress",
+				"openshift-dns/pod-0 ingress,egress",
+				"openshiftish/pod-0 ingress,egress",
+				"shop/web-0 egress",
+			},
+		},
+		{
+			name:    "system namespaces excluded by default",
+			exclude: defaultExcludedNamespaces,
+			want: []string{
+				"edge/lb-1 ingress,egress",
+				"kubevirt/pod-0 ingress,egress",
+				"openshiftish/pod-0 ingress,egress",
+				"shop/web-0 egress",
+			},
+		},
+		{
+			name:    "namespaces excluded by pattern",
+			exclude: "^(edge|shop)$|-",
+			want:    []string{"kube/pod-0 ingress,egress", "kubevirt/pod-0 ingress,egress", "openshiftish/pod-0 ingress,egress"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var exclude *regexp.Regexp
+			if test.exclude != "" {
+				exclude = regexp.MustCompile(test.exclude)
+			}
+			var got []string
+			for _, namespace := range AuditUnprotected(policies, &manifests.Inventory, exclude) {
+				for _, pod := range namespace.Pods {
+					got = append(got, pod.Namespace+"/"+pod.Pod+" "+pod.Unprotected)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got unprotected pods\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+// TestUnprotectedExitStatus runs the command in a subprocess to check its exit status
+func TestUnprotectedExitStatus(t *testing.T) {
+	if args := os.Getenv("NSM_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"NetworkPolicyExporter"}, strings.Split(args, " ")...)
+		main()
+		os.Exit(0)
+	}
+
+	fixture := filepath.Join("testdata", "v.yaml")
+	tests := []struct {
+		args   string
+		status int
+	}{
+		{args: "-f " + fixture + " -unprotected", status: 0},
+		{args: "-f " + fixture + " -unprotected -fail-unprotected", status: 3},
+		{args: "-f " + fixture + " -unprotected -fail-unprotected -exclude-namespaces ^(edge|shop)$", status: 0},
+		{args: "-f " + fixture + " -unprotected -exclude-namespaces (", status: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestUnprotectedExitStatus$")
+			cmd.Env = append(os.Environ(), "NSM_TEST_ARGS="+test.args)
+			status := 0
+			if err := cmd.Run(); err != nil {
+				exit, ok := err.(*exec.ExitError)
+				if !ok {
+					t.Fatal(err)
+				}
+				status = exit.ExitCode()
+			}
+			if status != test.status {
+				t.Errorf("got exit status %d, want %d", status, test.status)
+			}
+		})
+	}
+}
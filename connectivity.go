@@ -0,0 +1,514 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Endpoint is a source or destination of traffic, either a pod or an external address
+// pods have a Namespace, external addresses only have IPs
+// Resolved is set for the pods of the inventory, the ones resolved workloads can refer to
+// Network is the secondary network the endpoint is reached on, empty for the cluster network
+type Endpoint struct {
+	Namespace       string
+	Name            string
+	NamespaceLabels labels.Set
+	Labels          labels.Set
+	IPs             []string
+	Resolved        bool
+	Network         string
+}
+
+// IsPod reports if an endpoint is a pod that network policies can select
+func (e Endpoint) IsPod() bool {
+	return e.Namespace != ""
+}
+
+// DNSName reports if the endpoint is an external DNS name, its addresses unknown
+func (e Endpoint) DNSName() bool {
+	return !e.IsPod() && len(e.IPs) == 0 && e.Name != ""
+}
+
+// PortSet is a set of protocol/port keys as formatted by portKey, or all ports but the Excluded keys
+// a protocol/start-end key holds the ports of a range, it is split when some of its ports are taken out
+type PortSet struct {
+	All      bool
+	Ports    map[string]bool
+	Excluded map[string]bool
+}
+
+// AllPorts returns the set of all ports
+func AllPorts() PortSet {
+	return PortSet{All: true}
+}
+
+// allPortsExcept returns the set of all ports but the given keys
+func allPortsExcept(keys ...string) PortSet {
+	set := AllPorts()
+	for _, key := range keys {
+		if set.Excluded == nil {
+			set.Excluded = map[string]bool{}
+		}
+		set.Excluded[key] = true
+	}
+	return set
+}
+
+// excludes reports if some port of a key, or its whole protocol, is excluded from all ports
+func (s PortSet) excludes(key string) bool {
+	for excluded := range s.Excluded {
+		if _, ok := intersectKeys(excluded, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// NewPortSet returns the set of the given port keys
+func NewPortSet(keys ...string) PortSet {
+	set := PortSet{Ports: map[string]bool{}}
+	for _, key := range keys {
+		set.Ports[key] = true
+	}
+	return set
+}
+
+// Empty reports if no port is in the set
+func (s PortSet) Empty() bool {
+	return !s.All && len(s.Ports) == 0
+}
+
+// excludedSet returns the excluded keys of all ports as a set
+func (s PortSet) excludedSet() PortSet {
+	set := NewPortSet()
+	for key := range s.Excluded {
+		set.Ports[key] = true
+	}
+	return set
+}
+
+// Union returns the ports in either set
+func (s PortSet) Union(other PortSet) PortSet {
+	switch {
+	case s.All && other.All:
+		return allPortsExcept(s.excludedSet().Intersect(other.excludedSet()).keys()...)
+	case s.All || other.All:
+		all, some := s, other
+		if other.All {
+			all, some = other, s
+		}
+		return allPortsExcept(all.excludedSet().Subtract(some).keys()...)
+	}
+	union := NewPortSet()
+	for key := range s.Ports {
+		union.Ports[key] = true
+	}
+	for key := range other.Ports {
+		union.Ports[key] = true
+	}
+	return union
+}
+
+// Intersect returns the ports in both sets, a protocol key holds every port of that protocol
+func (s PortSet) Intersect(other PortSet) PortSet {
+	switch {
+	case s.All && other.All:
+		var excluded []string
+		for key := range s.Excluded {
+			excluded = append(excluded, key)
+		}
+		for key := range other.Excluded {
+			excluded = append(excluded, key)
+		}
+		return allPortsExcept(excluded...)
+	case s.All || other.All:
+		all, some := s, other
+		if other.All {
+			all, some = other, s
+		}
+		return some.Subtract(all.excludedSet())
+	}
+	intersection := NewPortSet()
+	for a := range s.Ports {
+		for b := range other.Ports {
+			if key, ok := intersectKeys(a, b); ok {
+				intersection.Ports[key] = true
+			}
+		}
+	}
+	return intersection
+}
+
+// Subtract returns the ports of the set not in the other one
+func (s PortSet) Subtract(other PortSet) PortSet {
+	switch {
+	case other.All:
+		// only the ports the other set excludes remain
+		return s.Intersect(other.excludedSet())
+	case s.All:
+		var excluded []string
+		for key := range s.Excluded {
+			excluded = append(excluded, key)
+		}
+		for key := range other.Ports {
+			excluded = append(excluded, key)
+		}
+		return allPortsExcept(excluded...)
+	}
+	difference := NewPortSet()
+	for key := range s.Ports {
+		for _, remaining := range subtractKeys(key, other.Ports) {
+			difference.Ports[remaining] = true
+		}
+	}
+	return difference
+}
+
+// Contains reports if every port of a protocol/port key is in the set
+func (s PortSet) Contains(key string) bool {
+	if s.All {
+		return !s.excludes(key)
+	}
+	return len(subtractKeys(key, s.Ports)) == 0
+}
+
+// keys returns the keys of the set, unsorted
+func (s PortSet) keys() []string {
+	var keys []string
+	for key := range s.Ports {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// portKeyRange returns the protocol and the first and last port of a protocol/port or protocol/start-end key,
+// false for named ports and whole protocols
+func portKeyRange(key string) (string, int, int, bool) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return "", 0, 0, false
+	}
+	bounds := strings.SplitN(parts[1], "-", 2)
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return "", 0, 0, false
+	}
+	end := start
+	if len(bounds) == 2 {
+		if end, err = strconv.Atoi(bounds[1]); err != nil {
+			return "", 0, 0, false
+		}
+	}
+	return parts[0], start, end, true
+}
+
+// portRangeKey formats the ports from start to end of a protocol as a key, protocol/port for a single port
+func portRangeKey(protocol string, start int, end int) string {
+	if start == end {
+		return fmt.Sprintf("%s/%d", protocol, start)
+	}
+	return fmt.Sprintf("%s/%d-%d", protocol, start, end)
+}
+
+// keyCovers reports if the ports of a key hold every port of another key
+func keyCovers(outer string, inner string) bool {
+	if outer == inner || strings.HasPrefix(inner, outer+"/") {
+		return true
+	}
+	protocol, start, end, ok := portKeyRange(outer)
+	innerProtocol, innerStart, innerEnd, innerOk := portKeyRange(inner)
+	return ok && innerOk && protocol == innerProtocol && start <= innerStart && innerEnd <= end
+}
+
+// intersectKeys returns the key of the ports two keys have in common, false when they have none
+func intersectKeys(a string, b string) (string, bool) {
+	switch {
+	case keyCovers(a, b):
+		return b, true
+	case keyCovers(b, a):
+		return a, true
+	}
+	protocol, start, end, ok := portKeyRange(a)
+	otherProtocol, otherStart, otherEnd, otherOk := portKeyRange(b)
+	if !ok || !otherOk || protocol != otherProtocol || otherStart > end || start > otherEnd {
+		return "", false
+	}
+	if otherStart > start {
+		start = otherStart
+	}
+	if otherEnd < end {
+		end = otherEnd
+	}
+	return portRangeKey(protocol, start, end), true
+}
+
+// subtractKeys returns the keys of the ports of a key no other key holds, a range loses the ports of the others
+// as smaller ranges, a whole protocol or a named port is only taken out by a key covering it
+func subtractKeys(key string, others map[string]bool) []string {
+	remaining := []string{key}
+	for other := range others {
+		var next []string
+		for _, current := range remaining {
+			if keyCovers(other, current) {
+				continue
+			}
+			protocol, start, end, ok := portKeyRange(current)
+			otherProtocol, otherStart, otherEnd, otherOk := portKeyRange(other)
+			if !ok || !otherOk || protocol != otherProtocol || otherStart > end || start > otherEnd {
+				next = append(next, current)
+				continue
+			}
+			if start < otherStart {
+				next = append(next, portRangeKey(protocol, start, otherStart-1))
+			}
+			if otherEnd < end {
+				next = append(next, portRangeKey(protocol, otherEnd+1, end))
+			}
+		}
+		remaining = next
+	}
+	return remaining
+}
+
+// Keys returns the sorted port keys of the set, ANY for all ports
+func (s PortSet) Keys() []string {
+	if s.All && len(s.Excluded) == 0 {
+		return []string{"ANY"}
+	}
+	var keys []string
+	if s.All {
+		for key := range s.Excluded {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return []string{"ANY except " + strings.Join(keys, ",")}
+	}
+	for key := range s.Ports {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s PortSet) String() string {
+	return strings.Join(s.Keys(), ",")
+}
+
+// rulePorts returns the ports a rule applies to
+func rulePorts(rule FirewallRule) PortSet {
+	location := rule.PortsLocation()
+	if location.AllPorts || (len(location.Ports) == 0 && len(location.PortRanges) == 0) {
+		return AllPorts()
+	}
+	set := NewPortSet(location.PortRanges...)
+	for _, port := range location.Ports {
+		set.Ports[portKey(port)] = true
+	}
+	return set
+}
+
+// Verdict is the evaluation of one direction of a connection on the pod the policies apply to
+// traffic is limited to Ports only when the pod is Isolated by the Isolating policies,
+// Rules are the allow rules granting Ports and Rejected the rules rejecting except blocks of the peer
+// EgressFirewall are the egress firewall rules matching an external peer, in order, the first one matching a port decides it
+type Verdict struct {
+	Isolated       bool
+	Isolating      []PolicyReference
+	Ports          PortSet
+	Rules          []FirewallRule
+	Rejected       []FirewallRule
+	EgressFirewall []FirewallRule
+	Admin          []FirewallRule
+	Baseline       []FirewallRule
+}
+
+// Allowed returns the ports the direction lets through, the admin tier decides first and passes the ports
+// it does not match to the network policies, the baseline tier decides for pods they do not isolate
+// egress firewalls apply on top
+func (v Verdict) Allowed() PortSet {
+	allowed := v.Ports
+	if !v.Isolated {
+		allowed = firstMatchPorts(v.Baseline, AllPorts())
+	}
+	allowed = firstMatchPorts(v.Admin, allowed)
+	return allowed.Intersect(firstMatchPorts(v.EgressFirewall, AllPorts()))
+}
+
+// firstMatchPorts returns the ports ordered rules allow, the first rule matching a port decides it
+// the ports passed or matched by no rule are left to the next ports
+func firstMatchPorts(rules []FirewallRule, next PortSet) PortSet {
+	allowed, decided := NewPortSet(), NewPortSet()
+	for _, rule := range rules {
+		ports := rulePorts(rule).Subtract(decided)
+		switch rule.Action {
+		case ActionAllow:
+			allowed = allowed.Union(ports)
+		case ActionPass:
+			allowed = allowed.Union(ports.Intersect(next))
+		}
+		decided = decided.Union(ports)
+	}
+	return allowed.Union(next.Subtract(decided))
+}
+
+// EvaluateDirection evaluates the rules of one direction for the subject pod selected by policies
+// and its peer, the destination and source for ingress, the source and destination for egress
+func EvaluateDirection(policies []FirewallPolicy, direction netv1.PolicyType, subject Endpoint, peer Endpoint) Verdict {
+	verdict := Verdict{Ports: NewPortSet()}
+	if !subject.IsPod() {
+		return verdict
+	}
+
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			if rule.Direction != direction {
+				continue
+			}
+			target, other := rule.To, rule.From
+			if direction == netv1.PolicyTypeEgress {
+				target, other = rule.From, rule.To
+			}
+			if !target.matchesEndpoint(subject) {
+				continue
+			}
+
+			// egress firewalls only apply to traffic leaving the cluster and do not isolate pods
+			if egressFirewallKinds[rule.Policy.Kind] {
+				if !peer.IsPod() && other.matchesEndpoint(peer) {
+					verdict.EgressFirewall = append(verdict.EgressFirewall, rule)
+				}
+				continue
+			}
+
+			// admin tiers do not isolate pods either
+			if rule.Tier != "" {
+				if !other.matchesEndpoint(peer) {
+					continue
+				}
+				if rule.Tier == TierAdmin {
+					verdict.Admin = append(verdict.Admin, rule)
+				} else {
+					verdict.Baseline = append(verdict.Baseline, rule)
+				}
+				continue
+			}
+
+			switch rule.Action {
+			case ActionDeny:
+				verdict.Isolated = true
+				verdict.Isolating = append(verdict.Isolating, rule.Policy)
+			case ActionAllow:
+				if other.matchesEndpoint(peer) {
+					verdict.Ports = verdict.Ports.Union(rulePorts(rule))
+					verdict.Rules = append(verdict.Rules, rule)
+				}
+			case ActionReject:
+				if other.matchesEndpoint(peer) {
+					verdict.Rejected = append(verdict.Rejected, rule)
+				}
+			}
+		}
+	}
+
+	for _, rules := range [][]FirewallRule{verdict.EgressFirewall, verdict.Admin, verdict.Baseline} {
+		sort.SliceStable(rules, func(i, j int) bool {
+			return tierLess(rules[i], rules[j])
+		})
+	}
+	return verdict
+}
+
+// Connection is the evaluation of traffic from a source to a destination on both sides
+type Connection struct {
+	Egress  Verdict
+	Ingress Verdict
+}
+
+// Allowed returns the ports allowed by the egress side of the source and the ingress side of the destination
+func (c Connection) Allowed() PortSet {
+	return c.Egress.Allowed().Intersect(c.Ingress.Allowed())
+}
+
+// EvaluateConnection evaluates traffic from source to destination
+func EvaluateConnection(policies []FirewallPolicy, source Endpoint, destination Endpoint) Connection {
+	return Connection{
+		Egress:  EvaluateDirection(policies, netv1.PolicyTypeEgress, source, destination),
+		Ingress: EvaluateDirection(policies, netv1.PolicyTypeIngress, destination, source),
+	}
+}
+
+// matchesEndpoint reports if an endpoint is part of a location
+// a location with resolved workloads only holds these pods among the resolved ones
+// and a location only holds the endpoints of its network
+func (l FirewallLocation) matchesEndpoint(e Endpoint) bool {
+	if l.Network != e.Network {
+		return false
+	}
+	if e.Resolved && len(l.Workloads) > 0 && !l.hasWorkload(e) {
+		return false
+	}
+	switch {
+	case l.Any:
+		return true
+	case l.DNSName != "":
+		return !e.IsPod() && dnsNameMatches(l.DNSName, e.Name)
+	case l.CIDR != "" && e.DNSName():
+		// the address behind a name is unknown, only a CIDR of every address holds it
+		return isWorldCIDR(l.CIDR) && len(l.Except) == 0
+	case l.CIDR != "":
+		for _, ip := range e.IPs {
+			if cidrContains(l.CIDR, ip) && !l.excepted(ip) {
+				return true
+			}
+		}
+		return false
+	case !e.IsPod():
+		return false
+	}
+	return l.matchesPod(e.Namespace, e.NamespaceLabels, e.Labels)
+}
+
+// hasWorkload reports if a pod endpoint is one of the resolved workloads of a location
+func (l FirewallLocation) hasWorkload(e Endpoint) bool {
+	for _, workload := range l.Workloads {
+		if workload.Namespace == e.Namespace && workload.Pod == e.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// excepted reports if an IP is in the except blocks of a CIDR location
+func (l FirewallLocation) excepted(ip string) bool {
+	for _, except := range l.Except {
+		if cidrContains(except, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsNameMatches reports if a name is a DNS name or, for a *. wildcard, one of its subdomains
+func dnsNameMatches(pattern string, name string) bool {
+	pattern, name = strings.TrimSuffix(strings.ToLower(pattern), "."), strings.TrimSuffix(strings.ToLower(name), ".")
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(name, pattern[1:])
+	}
+	return pattern == name
+}
+
+// cidrContains reports if an IP is part of a CIDR
+func cidrContains(cidr string, ip string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	return parsed != nil && network.Contains(parsed)
+}
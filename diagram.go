@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+)
+
+// Kinds of the nodes of a diagram
+const (
+	diagramPods = "pods"
+	diagramCIDR = "cidr"
+	diagramAny  = "any"
+)
+
+// diagramNode is a namespace, workload, selector, CIDR or any address of a diagram
+// Isolated is set for pods whose ingress is isolated without any rule allowing traffic to them
+type diagramNode struct {
+	Name     string
+	Kind     string
+	Isolated bool
+}
+
+// diagramEdge is the traffic allowed, or rejected for except blocks, from a node to another
+type diagramEdge struct {
+	From   string
+	To     string
+	Action string
+	Ports  PortSet
+}
+
+// diagram holds the sorted nodes and edges drawn from the allow and reject rules
+type diagram struct {
+	Nodes []diagramNode
+	Edges []diagramEdge
+}
+
+// newDiagram draws the rules of the policies at workload or namespace level, leaving out the egress firewall rules
+// selectors are drawn as the workloads they resolved to, or as themselves when they are not resolved
+func newDiagram(policies []FirewallPolicy, inventory *Inventory, level string) diagram {
+	nodes := map[string]*diagramNode{}
+	addNode := func(name string, kind string) {
+		if nodes[name] == nil {
+			nodes[name] = &diagramNode{Name: name, Kind: kind}
+		}
+	}
+
+	edges := map[string]*diagramEdge{}
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			// egress firewall rules only filter what the network policies allow, they open no flow
+			if rule.Action == ActionDeny || rule.Action == ActionPass || egressFirewallKinds[rule.Policy.Kind] {
+				continue
+			}
+			froms, fromKind := diagramLocationNodes(rule.From, level)
+			tos, toKind := diagramLocationNodes(rule.To, level)
+			for _, from := range froms {
+				addNode(from, fromKind)
+				for _, to := range tos {
+					addNode(to, toKind)
+					key := from + "\x00" + to + "\x00" + rule.Action
+					if edges[key] == nil {
+						edges[key] = &diagramEdge{From: from, To: to, Action: rule.Action, Ports: NewPortSet()}
+					}
+					edges[key].Ports = edges[key].Ports.Union(rulePorts(rule))
+				}
+			}
+		}
+	}
+
+	// pods without any policy are drawn too, the isolated ones nothing can reach are highlighted
+	for _, group := range matrixGroups(policies, inventory, level) {
+		addNode(group.name, diagramPods)
+		isolated, reachable := false, false
+		for _, endpoint := range group.endpoints {
+			verdict := ingressTargets(policies, endpoint)
+			isolated = isolated || verdict.Isolated
+			reachable = reachable || !verdict.Isolated || len(verdict.Rules) > 0
+		}
+		nodes[group.name].Isolated = isolated && !reachable
+	}
+
+	var d diagram
+	for _, node := range nodes {
+		d.Nodes = append(d.Nodes, *node)
+	}
+	sort.Slice(d.Nodes, func(i, j int) bool {
+		return d.Nodes[i].Name < d.Nodes[j].Name
+	})
+	for _, edge := range edges {
+		d.Edges = append(d.Edges, *edge)
+	}
+	sort.Slice(d.Edges, func(i, j int) bool {
+		a, b := d.Edges[i], d.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Action < b.Action
+	})
+	return d
+}
+
+// diagramLocationNodes returns the names of the nodes of a location and their kind
+func diagramLocationNodes(location FirewallLocation, level string) ([]string, string) {
+	switch {
+	case location.Network != "":
+		// nodes of secondary networks are told apart from the ones of the cluster network
+		cluster := location
+		cluster.Network = ""
+		names, kind := diagramLocationNodes(cluster, level)
+		for i := range names {
+			names[i] += "@" + location.Network
+		}
+		return names, kind
+	case location.Any:
+		return []string{"any"}, diagramAny
+	case location.CIDR != "":
+		return []string{location.CIDR}, diagramCIDR
+	case location.DNSName != "":
+		return []string{location.DNSName}, diagramCIDR
+	}
+
+	var names []string
+	for _, workload := range location.Workloads {
+		if level == MatrixNamespace {
+			names = append(names, workload.Namespace)
+		} else {
+			names = append(names, workload.Name())
+		}
+	}
+	if len(location.Workloads) == 0 {
+		if level == MatrixNamespace {
+			names = append(names, formatNamespace(location))
+		} else {
+			names = append(names, FormatLocation(location))
+		}
+	}
+	return uniqueSorted(names), diagramPods
+}
+
+// ingressTargets evaluates the ingress rules selecting a pod, Rules are all the allow rules targeting it
+func ingressTargets(policies []FirewallPolicy, endpoint Endpoint) Verdict {
+	var verdict Verdict
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			if rule.Direction != netv1.PolicyTypeIngress || !rule.To.matchesEndpoint(endpoint) {
+				continue
+			}
+			switch rule.Action {
+			case ActionDeny:
+				// admin tier denies do not isolate the pods
+				if rule.Tier != "" {
+					continue
+				}
+				verdict.Isolated = true
+				verdict.Isolating = append(verdict.Isolating, rule.Policy)
+			case ActionAllow:
+				verdict.Rules = append(verdict.Rules, rule)
+			}
+		}
+	}
+	return verdict
+}
+
+// edgeLabel returns the label of an edge, the ports prefixed by REJECT for except blocks
+func edgeLabel(edge diagramEdge) string {
+	if edge.Action == ActionReject {
+		return ActionReject + " " + edge.Ports.String()
+	}
+	return edge.Ports.String()
+}
+
+// dotExporter draws the rules as a Graphviz digraph
+type dotExporter struct {
+	inventory *Inventory
+	level     string
+}
+
+func (e dotExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	d := newDiagram(policies, e.inventory, e.level)
+
+	fmt.Fprintln(w, "digraph networkpolicies {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	for _, node := range d.Nodes {
+		var attributes []string
+		switch node.Kind {
+		case diagramCIDR:
+			attributes = append(attributes, "shape=ellipse")
+		case diagramAny:
+			attributes = append(attributes, "shape=diamond")
+		}
+		if node.Isolated {
+			attributes = append(attributes, "style=filled", "fillcolor=\"#f4cccc\"", "color=\"#cc0000\"", "tooltip=\"isolated, no traffic allowed in\"")
+		}
+		fmt.Fprintf(w, "\t%q", node.Name)
+		if len(attributes) > 0 {
+			fmt.Fprintf(w, " [%s]", strings.Join(attributes, ", "))
+		}
+		fmt.Fprintln(w, ";")
+	}
+	for _, edge := range d.Edges {
+		attributes := []string{fmt.Sprintf("label=%q", edgeLabel(edge))}
+		if edge.Action == ActionReject {
+			attributes = append(attributes, "color=\"#cc0000\"", "style=dashed", "arrowhead=tee")
+		}
+		fmt.Fprintf(w, "\t%q -> %q [%s];\n", edge.From, edge.To, strings.Join(attributes, ", "))
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// mermaidExporter draws the rules as a Mermaid flowchart
+type mermaidExporter struct {
+	inventory *Inventory
+	level     string
+}
+
+func (e mermaidExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	d := newDiagram(policies, e.inventory, e.level)
+
+	fmt.Fprintln(w, "flowchart LR")
+	ids := map[string]string{}
+	var isolated []string
+	for i, node := range d.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[node.Name] = id
+		text := strings.Replace(node.Name, "\"", "#quot;", -1)
+		switch node.Kind {
+		case diagramCIDR:
+			fmt.Fprintf(w, "\t%s([\"%s\"])\n", id, text)
+		case diagramAny:
+			fmt.Fprintf(w, "\t%s{\"%s\"}\n", id, text)
+		default:
+			fmt.Fprintf(w, "\t%s[\"%s\"]\n", id, text)
+		}
+		if node.Isolated {
+			isolated = append(isolated, id)
+		}
+	}
+	for _, edge := range d.Edges {
+		arrow := "-->"
+		if edge.Action == ActionReject {
+			arrow = "-.-x"
+		}
+		fmt.Fprintf(w, "\t%s %s|\"%s\"| %s\n", ids[edge.From], arrow, edgeLabel(edge), ids[edge.To])
+	}
+	if len(isolated) > 0 {
+		fmt.Fprintln(w, "\tclassDef isolated fill:#f4cccc,stroke:#cc0000")
+		fmt.Fprintf(w, "\tclass %s isolated\n", strings.Join(isolated, ","))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestDiagramGolden compares the dot and mermaid diagrams of the fixtures at both levels with their golden files
+func TestDiagramGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+	}{
+		{name: "v", fixture: "v.yaml"},
+		{name: "egress-firewall", fixture: "egress-firewall.yaml"},
+	}
+	for _, test := range tests {
+		for _, format := range []string{"dot", "mermaid"} {
+			for _, level := range []string{MatrixWorkload, MatrixNamespace} {
+				t.Run(test.name+"/"+format+"/"+level, func(t *testing.T) {
+					manifests, err := LoadManifests([]string{filepath.Join("testdata", test.fixture)})
+					if err != nil {
+						t.Fatal(err)
+					}
+					translator := NewTranslator(0)
+					translator.TranslatePolicies(manifests.Policies)
+					translated := translator.TranslateEgressFirewalls(manifests.EgressFirewalls)
+					ResolveWorkloads(translated, &manifests.Inventory)
+					ResolveNamedPorts(translated, &manifests.Inventory)
+
+					exporter, err := NewExporter(format, ExportOptions{Inventory: &manifests.Inventory, Level: level})
+					if err != nil {
+						t.Fatal(err)
+					}
+					var out bytes.Buffer
+					if err := exporter.Export(&out, translated); err != nil {
+						t.Fatal(err)
+					}
+
+					golden := filepath.Join("testdata", test.name+"."+level+"."+format)
+					if *update {
+						if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+							t.Fatal(err)
+						}
+					}
+					want, err := ioutil.ReadFile(golden)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(out.Bytes(), want) {
+						t.Errorf("%s output differs from %s, run go test -update after checking it\n%s", format, golden, out.String())
+					}
+				})
+			}
+		}
+	}
+}
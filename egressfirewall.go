@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kinds of the egress firewalls, the openshift-sdn one and the OVN-Kubernetes one
+const (
+	KindEgressNetworkPolicy = "EgressNetworkPolicy"
+	KindEgressFirewall      = "EgressFirewall"
+)
+
+// egressFirewallGroups maps the kinds of the egress firewalls to their API group
+var egressFirewallGroups = map[string]schema.GroupVersion{
+	KindEgressNetworkPolicy: {Group: "network.openshift.io", Version: "v1"},
+	KindEgressFirewall:      {Group: "k8s.ovn.org", Version: "v1"},
+}
+
+// egressFirewallKinds are the kinds of the policies whose rules are egress firewall rules
+var egressFirewallKinds = map[string]bool{
+	KindEgressNetworkPolicy: true,
+	KindEgressFirewall:      true,
+}
+
+// EgressFirewall is an EgressNetworkPolicy or an EgressFirewall, they share their rules, only EgressFirewall has ports
+// the rules apply in order to the traffic of every pod of the namespace leaving the cluster, the first matching
+// rule decides and traffic no rule matches is allowed
+type EgressFirewall struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              EgressFirewallSpec `json:"spec"`
+}
+
+// EgressFirewallSpec holds the ordered rules of an egress firewall
+type EgressFirewallSpec struct {
+	Egress []EgressFirewallRule `json:"egress"`
+}
+
+// EgressFirewallRule allows or denies a destination
+type EgressFirewallRule struct {
+	Type  string                    `json:"type"`
+	To    EgressFirewallDestination `json:"to"`
+	Ports []EgressFirewallPort      `json:"ports,omitempty"`
+}
+
+// EgressFirewallDestination is a CIDR, a DNS name or, for EgressFirewall, the nodes matching a selector
+type EgressFirewallDestination struct {
+	CIDRSelector string                `json:"cidrSelector,omitempty"`
+	DNSName      string                `json:"dnsName,omitempty"`
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+}
+
+// EgressFirewallPort is a destination port, all ports of the protocol without a port
+type EgressFirewallPort struct {
+	Protocol string `json:"protocol"`
+	Port     int32  `json:"port,omitempty"`
+}
+
+// LoadEgressFirewalls lists the EgressNetworkPolicies and EgressFirewalls through the controller-runtime client,
+// the kinds the cluster does not serve are skipped
+func LoadEgressFirewalls(c client.Client) ([]EgressFirewall, error) {
+	var firewalls []EgressFirewall
+	for _, kind := range []string{KindEgressNetworkPolicy, KindEgressFirewall} {
+		list := new(unstructured.UnstructuredList)
+		list.SetGroupVersionKind(egressFirewallGroups[kind].WithKind(kind + "List"))
+		if err := c.List(context.Background(), list); err != nil {
+			if meta.IsNoMatchError(err) || apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, item := range list.Items {
+			var firewall EgressFirewall
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &firewall); err != nil {
+				return nil, fmt.Errorf("%s %s/%s: %v", kind, item.GetNamespace(), item.GetName(), err)
+			}
+			firewall.Kind = kind
+			firewalls = append(firewalls, firewall)
+		}
+	}
+	return firewalls, nil
+}
+
+// EgressFirewallTranslator translates an egress firewall into a FirewallPolicy of egress rules from every pod of
+// its namespace in the order of the egress firewall, rules to nodes are left out as nodes are not resolved
+func (t *Translator) EgressFirewallTranslator(firewall EgressFirewall) {
+	t.policy = &FirewallPolicy{Namespace: firewall.Namespace, Name: firewall.Name, Kind: firewall.Kind}
+	source := FirewallLocation{Namespace: firewall.Namespace, PodSelector: &metav1.LabelSelector{}}
+
+	for i, egress := range firewall.Spec.Egress {
+		action := ActionAllow
+		if egress.Type == "Deny" {
+			action = ActionDeny
+		}
+		destination := FirewallLocation{CIDR: egress.To.CIDRSelector, DNSName: egress.To.DNSName}
+		if destination.CIDR == "" && destination.DNSName == "" {
+			println("skipped", firewall.Kind, firewall.Namespace+"/"+firewall.Name, "rule", i, ": node selector destinations are not supported")
+			continue
+		}
+
+		var ports []netv1.NetworkPolicyPort
+		for _, port := range egress.Ports {
+			protocol := corev1.Protocol(port.Protocol)
+			policyPort := netv1.NetworkPolicyPort{Protocol: &protocol}
+			if port.Port != 0 {
+				number := intstr.FromInt(int(port.Port))
+				policyPort.Port = &number
+			}
+			ports = append(ports, policyPort)
+		}
+		t.appendRule(source, portsLocation(destination, ports), action, netv1.PolicyTypeEgress)
+	}
+
+	t.Policies = append(t.Policies, *t.policy)
+}
+
+// TranslateEgressFirewalls translates egress firewalls after the policies already translated,
+// each one following the last policy of its namespace so the rules of a namespace stay together
+func (t *Translator) TranslateEgressFirewalls(firewalls []EgressFirewall) []FirewallPolicy {
+	for _, firewall := range firewalls {
+		t.EgressFirewallTranslator(firewall)
+		policies := t.Policies
+
+		translated := policies[len(policies)-1]
+		position := len(policies) - 1
+		for i := range policies[:len(policies)-1] {
+			if policies[i].Namespace == translated.Namespace {
+				position = i + 1
+			}
+		}
+		copy(policies[position+1:], policies[position:len(policies)-1])
+		policies[position] = translated
+	}
+	return t.Policies
+}
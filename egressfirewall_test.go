@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEgressFirewallFirstMatch(t *testing.T) {
+	name := func(action string, dnsName string) EgressFirewallRule {
+		return EgressFirewallRule{Type: action, To: EgressFirewallDestination{DNSName: dnsName}}
+	}
+	cidr := func(action string, cidr string, ports ...EgressFirewallPort) EgressFirewallRule {
+		return EgressFirewallRule{Type: action, To: EgressFirewallDestination{CIDRSelector: cidr}, Ports: ports}
+	}
+	https := EgressFirewallPort{Protocol: "TCP", Port: 443}
+	rules := []EgressFirewallRule{
+		name("Allow", "www.example.com"),
+		name("Deny", "*.example.com"),
+		cidr("Allow", "1.2.3.0/24", https),
+		cidr("Deny", "1.2.3.0/24"),
+		cidr("Deny", "0.0.0.0/0"),
+	}
+
+	tests := []struct {
+		name    string
+		rules   []EgressFirewallRule
+		to      string
+		port    string
+		allowed bool
+		// rule is the action and destination of the egress firewall rule deciding the port, empty for none
+		rule string
+	}{
+		{name: "name before its wildcard", rules: rules, to: "www.example.com", port: "TCP/443", allowed: true, rule: "ALLOW www.example.com"},
+		{name: "wildcard", rules: rules, to: "api.example.com", port: "TCP/443", rule: "DENY *.example.com"},
+		{name: "wildcard holds only subdomains", rules: rules, to: "example.com", port: "TCP/443", rule: "DENY 0.0.0.0/0"},
+		{name: "allowed port of a block", rules: rules, to: "1.2.3.4", port: "TCP/443", allowed: true, rule: "ALLOW 1.2.3.0/24"},
+		{name: "other port of the same block", rules: rules, to: "1.2.3.4", port: "TCP/80", rule: "DENY 1.2.3.0/24"},
+		{name: "last rule", rules: rules, to: "8.8.8.8", port: "UDP/53", rule: "DENY 0.0.0.0/0"},
+		{name: "pods are left to network policies", rules: rules, to: "shop/web-0", port: "TCP/80", allowed: true},
+		{
+			name:  "wildcard before the name",
+			rules: []EgressFirewallRule{name("Deny", "*.example.com"), name("Allow", "www.example.com")},
+			to:    "www.example.com", port: "TCP/443", rule: "DENY *.example.com",
+		},
+		{
+			name:  "name case and trailing dot",
+			rules: []EgressFirewallRule{name("Allow", "WWW.Example.com."), cidr("Deny", "0.0.0.0/0")},
+			to:    "www.example.com", port: "TCP/443", allowed: true, rule: "ALLOW WWW.Example.com.",
+		},
+		{
+			name:  "block of a name unknown",
+			rules: []EgressFirewallRule{cidr("Deny", "93.184.0.0/16"), cidr("Allow", "0.0.0.0/0")},
+			to:    "www.example.com", port: "TCP/443", allowed: true, rule: "ALLOW 0.0.0.0/0",
+		},
+		{
+			name:  "no matching rule",
+			rules: []EgressFirewallRule{cidr("Deny", "1.2.3.0/24")},
+			to:    "8.8.8.8", port: "UDP/53", allowed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifests, err := LoadManifests([]string{filepath.Join("testdata", "egress-firewall.yaml")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			inventory := &manifests.Inventory
+			translator := NewTranslator(0)
+			translator.TranslatePolicies(manifests.Policies)
+			policies := translator.TranslateEgressFirewalls([]EgressFirewall{{
+				TypeMeta:   metav1.TypeMeta{Kind: KindEgressFirewall},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "default"},
+				Spec:       EgressFirewallSpec{Egress: test.rules},
+			}})
+			ResolveWorkloads(policies, inventory)
+			ResolveNamedPorts(policies, inventory)
+
+			source, err := ParseEndpoint("shop/api-0", inventory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			destination, err := ParseEndpoint(test.to, inventory)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := Query(policies, source, destination, test.port)
+			if result.Allowed != test.allowed {
+				t.Errorf("got allowed %v, want %v", result.Allowed, test.allowed)
+			}
+			var rule string
+			if decided := result.Egress.EgressFirewall; decided != nil {
+				rule = decided.Action + " " + FormatLocation(decided.To)
+			}
+			if rule != test.rule {
+				t.Errorf("got egress firewall rule %q, want %q", rule, test.rule)
+			}
+		})
+	}
+}
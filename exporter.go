@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Exporter renders translated firewall policies in an output format
+type Exporter interface {
+	Export(w io.Writer, policies []FirewallPolicy) error
+}
+
+// ExportOptions holds the context exporters can use beyond the policies
+type ExportOptions struct {
+	Inventory *Inventory
+	// Level is the level diagrams are drawn at, workload or namespace
+	Level string
+	// Merged is set when the policies are the single merged rulebase
+	Merged bool
+	// Exclude matches the namespaces left out of the unprotected pods
+	Exclude *regexp.Regexp
+	// ClusterCIDRs are the pod and service CIDRs the netfilter outputs keep the egress firewall rules away from
+	ClusterCIDRs []string
+}
+
+// exporters maps every output format to the constructor of its exporter
+var exporters = map[string]func(ExportOptions) Exporter{
+	"json":     func(options ExportOptions) Exporter { return jsonExporter{merged: options.Merged} },
+	"yaml":     func(ExportOptions) Exporter { return yamlExporter{} },
+	"csv":      func(ExportOptions) Exporter { return csvExporter{} },
+	"markdown": func(ExportOptions) Exporter { return markdownExporter{} },
+	"table":    func(ExportOptions) Exporter { return tableExporter{} },
+	"iptables": func(options ExportOptions) Exporter { return iptablesExporter{clusterCIDRs: options.ClusterCIDRs} },
+	"nftables": func(options ExportOptions) Exporter { return nftablesExporter{clusterCIDRs: options.ClusterCIDRs} },
+	"panos":    func(ExportOptions) Exporter { return panosExporter{} },
+	"asa":      func(ExportOptions) Exporter { return asaExporter{} },
+	"fortios":  func(ExportOptions) Exporter { return fortiosExporter{} },
+	"dot": func(options ExportOptions) Exporter {
+		return dotExporter{inventory: options.Inventory, level: options.Level}
+	},
+	"mermaid": func(options ExportOptions) Exporter {
+		return mermaidExporter{inventory: options.Inventory, level: options.Level}
+	},
+	"html": func(options ExportOptions) Exporter {
+		return htmlExporter{inventory: options.Inventory, exclude: options.Exclude}
+	},
+}
+
+// resolvingFormats lists the output formats rendering selectors with the pods and IPs of their resolved workloads
+var resolvingFormats = map[string]bool{
+	"iptables": true,
+	"nftables": true,
+	"panos":    true,
+	"asa":      true,
+	"fortios":  true,
+	"html":     true,
+}
+
+// zonedFormats lists the output formats writing rules for the zones assigned to their locations
+var zonedFormats = map[string]bool{
+	"panos":   true,
+	"asa":     true,
+	"fortios": true,
+}
+
+// NewExporter returns the exporter of an output format
+func NewExporter(format string, options ExportOptions) (Exporter, error) {
+	constructor, ok := exporters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q, use one of %s", format, strings.Join(ExportFormats(), ", "))
+	}
+	return constructor(options), nil
+}
+
+// ExportFormats returns the sorted names of the output formats
+func ExportFormats() []string {
+	var formats []string
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// FlatRule is a rule flattened into strings, the row shared by the tabular formats
+type FlatRule struct {
+	Namespace     string `header:"Namespace"`
+	Policy        string `header:"Policy"`
+	Order         int    `header:"Order,text"`
+	Direction     string `header:"Direction"`
+	Action        string `header:"Action"`
+	From          string `header:"From"`
+	To            string `header:"To"`
+	Ports         string `header:"Ports"`
+	FromWorkloads string `header:"From Workloads"`
+	ToWorkloads   string `header:"To Workloads"`
+	FromZones     string `header:"From Zones"`
+	ToZones       string `header:"To Zones"`
+	Tier          string `header:"Tier"`
+	Network       string `header:"Network"`
+}
+
+// flatRuleColumns are the column names of a FlatRule in the csv and markdown formats
+var flatRuleColumns = []string{"namespace", "policy", "order", "direction", "action", "from", "to", "ports", "fromWorkloads", "toWorkloads", "fromZones", "toZones", "tier", "network"}
+
+// cells returns the columns of a FlatRule
+func (r FlatRule) cells() []string {
+	return []string{r.Namespace, r.Policy, strconv.Itoa(r.Order), r.Direction, r.Action, r.From, r.To, r.Ports, r.FromWorkloads, r.ToWorkloads, r.FromZones, r.ToZones, r.Tier, r.Network}
+}
+
+// FlattenRules flattens the rules of all policies, selectors, CIDRs and ports are written the same way in every format
+// rows are grouped by zone pair when zones are assigned
+func FlattenRules(policies []FirewallPolicy) []FlatRule {
+	var rows []FlatRule
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			rows = append(rows, FlatRule{
+				Namespace:     rule.Policy.Namespace,
+				Policy:        policyName(rule.Policy),
+				Order:         rule.Order,
+				Direction:     string(rule.Direction),
+				Action:        rule.Action,
+				From:          FormatLocation(rule.From),
+				To:            FormatLocation(rule.To),
+				Ports:         FormatPorts(*rule.PortsLocation()),
+				FromWorkloads: formatWorkloads(rule.From.Workloads),
+				ToWorkloads:   formatWorkloads(rule.To.Workloads),
+				FromZones:     strings.Join(rule.From.Zones, " "),
+				ToZones:       strings.Join(rule.To.Zones, " "),
+				Tier:          formatTier(rule),
+				Network:       rule.To.Network,
+			})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].FromZones != rows[j].FromZones {
+			return rows[i].FromZones < rows[j].FromZones
+		}
+		return rows[i].ToZones < rows[j].ToZones
+	})
+	return rows
+}
+
+// formatTier returns the tier of a rule with the priority of the admin tier, empty for network policy rules
+func formatTier(rule FirewallRule) string {
+	if rule.Tier == TierAdmin {
+		return fmt.Sprintf("%s %d", rule.Tier, rule.Priority)
+	}
+	return rule.Tier
+}
+
+// policyName returns the name of a NetworkPolicy, kind/name for other policies
+func policyName(reference PolicyReference) string {
+	if reference.Kind == "" {
+		return reference.Name
+	}
+	return reference.Kind + "/" + reference.Name
+}
+
+// formatWorkloads joins the namespace/pod names of resolved workloads
+func formatWorkloads(workloads []Workload) string {
+	var names []string
+	for _, workload := range workloads {
+		names = append(names, workload.Namespace+"/"+workload.Pod)
+	}
+	return strings.Join(names, " ")
+}
+
+// jsonExporter writes the policies as JSON, a merged rulebase as the plain array of its rules
+type jsonExporter struct {
+	merged bool
+}
+
+func (e jsonExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	var output interface{} = policies
+	if e.merged {
+		var rules []FirewallRule
+		for _, policy := range policies {
+			rules = append(rules, policy.Rules...)
+		}
+		output = rules
+	}
+
+	out, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// yamlExporter writes the policies as YAML
+type yamlExporter struct{}
+
+func (yamlExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	out, err := yaml.Marshal(policies)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// csvExporter writes one flattened rule per line
+type csvExporter struct{}
+
+func (csvExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(flatRuleColumns); err != nil {
+		return err
+	}
+	for _, row := range FlattenRules(policies) {
+		if err := writer.Write(row.cells()); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// markdownExporter writes the flattened rules as a markdown table
+type markdownExporter struct{}
+
+func (markdownExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(flatRuleColumns, " | ")); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(flatRuleColumns))); err != nil {
+		return err
+	}
+	for _, row := range FlattenRules(policies) {
+		cells := row.cells()
+		for i := range cells {
+			cells[i] = strings.Replace(cells[i], "|", "\\|", -1)
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableExporter writes the flattened rules as a terminal table
+type tableExporter struct{}
+
+func (tableExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	newTablePrinter(w).Print(FlattenRules(policies))
+	return nil
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// addressObject is a named host or network of a vendor firewall
+type addressObject struct {
+	Name    string
+	Network *net.IPNet
+}
+
+// Host reports if the object is a single address
+func (a addressObject) Host() bool {
+	ones, bits := a.Network.Mask.Size()
+	return ones == bits
+}
+
+// IPv4 reports if the object is an IPv4 address or network
+func (a addressObject) IPv4() bool {
+	return a.Network.IP.To4() != nil
+}
+
+// serviceObject is a named protocol and port of a vendor firewall, a zero port stands for the whole protocol
+type serviceObject struct {
+	Name     string
+	Protocol string
+	Port     int
+}
+
+// firewallRule is a rule of a zone pair with its endpoints and ports turned into firewall objects
+// nil addresses or services stand for any, Skip explains why a rule cannot be rendered
+// Position is the place of the rule in the rulebase and Comment references the rules it comes from
+type firewallRule struct {
+	Rule     FirewallRule
+	Position int
+	Name     string
+	Comment  string
+	FromZone string
+	ToZone   string
+	From     []addressObject
+	To       []addressObject
+	Services []serviceObject
+	Skip     string
+}
+
+// firewallRulebase holds the rules of a vendor firewall grouped by zone pair with the objects they use
+// a rule spanning several zones is repeated in every pair, the rules of a pair keep the rulebase order
+type firewallRulebase struct {
+	Rules     []firewallRule
+	Skipped   []firewallRule
+	Addresses []addressObject
+	Services  []serviceObject
+}
+
+// newFirewallRulebase builds the rulebase of the policies from the zones assigned to their locations,
+// addresses come from CIDRs and the IPs of the resolved workloads
+// except blocks are left out of the addresses of their allow rules, only the other reject rules come first
+// a connection needs both the egress rules of its source and the ingress rules of its destination, a firewall
+// stops at the first matching rule, so the rulebase first allows what both sides allow, then drops the rest between
+// pods isolated on both sides, then checks the egress rules and the ingress rules of the pods isolated on one side
+func newFirewallRulebase(policies []FirewallPolicy) firewallRulebase {
+	var rulebase firewallRulebase
+	var rejects, egressAllows, egressDenies, ingressAllows, ingressDenies []firewallRule
+
+	merged := mergedRules(policies)
+	owners := exceptOwners(merged)
+	for i, rule := range merged {
+		r := newNetfilterRule(rule, owners[i])
+		f := firewallRule{
+			Rule:     rule,
+			Comment:  directionComment(rule),
+			From:     addressObjects(r.From),
+			To:       addressObjects(r.To),
+			Services: serviceObjects(*rule.PortsLocation()),
+			Skip:     r.Skip,
+		}
+		if f.Skip == "" && egressFirewallKinds[rule.Policy.Kind] {
+			f.Skip = "egress firewall rules apply before the rulebase and are not exported"
+		}
+		if f.Skip == "" && rule.Tier != "" {
+			f.Skip = rule.Tier + " tier rules apply around the network policies and are not exported"
+		}
+		if f.Skip == "" && (len(rule.From.Zones) == 0 || len(rule.To.Zones) == 0) {
+			f.Skip = "no zone"
+		}
+		if f.Skip != "" {
+			rulebase.Skipped = append(rulebase.Skipped, f)
+			continue
+		}
+		for _, port := range namedPorts(*rule.PortsLocation()) {
+			rulebase.Skipped = append(rulebase.Skipped, firewallRule{Rule: rule, Skip: "named port " + port})
+		}
+
+		egress := rule.Direction != netv1.PolicyTypeIngress
+		switch {
+		case rule.Action == ActionReject:
+			rejects = append(rejects, f)
+		case rule.Action == ActionAllow && egress:
+			egressAllows = append(egressAllows, f)
+		case rule.Action == ActionAllow:
+			ingressAllows = append(ingressAllows, f)
+		case egress:
+			egressDenies = append(egressDenies, f)
+		default:
+			ingressDenies = append(ingressDenies, f)
+		}
+	}
+
+	var ordered []firewallRule
+	ordered = append(ordered, rejects...)
+	for _, egress := range egressAllows {
+		for _, ingress := range ingressAllows {
+			if both, ok := bothAllowed(egress, ingress); ok {
+				ordered = append(ordered, both)
+			}
+		}
+	}
+	if isolated, ok := isolatedDeny(egressDenies, ingressDenies); ok {
+		ordered = append(ordered, isolated)
+	}
+	for _, rules := range [][]firewallRule{egressAllows, egressDenies, ingressAllows, ingressDenies} {
+		ordered = append(ordered, rules...)
+	}
+
+	addresses := map[string]bool{}
+	services := map[string]bool{}
+	pairs := map[string][]firewallRule{}
+	var keys []string
+	for i, f := range ordered {
+		f.Position = i
+		for _, from := range f.Rule.From.Zones {
+			for _, to := range f.Rule.To.Zones {
+				key := from + " " + to
+				if _, ok := pairs[key]; !ok {
+					keys = append(keys, key)
+				}
+				pair := f
+				pair.FromZone, pair.ToZone = from, to
+				pair.Name = fmt.Sprintf("nsm-%d-%s-%s", i, from, to)
+				pairs[key] = append(pairs[key], pair)
+			}
+		}
+		for _, address := range append(append([]addressObject{}, f.From...), f.To...) {
+			if !addresses[address.Name] {
+				addresses[address.Name] = true
+				rulebase.Addresses = append(rulebase.Addresses, address)
+			}
+		}
+		for _, service := range f.Services {
+			if !services[service.Name] {
+				services[service.Name] = true
+				rulebase.Services = append(rulebase.Services, service)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		rulebase.Rules = append(rulebase.Rules, pairs[key]...)
+	}
+	return rulebase
+}
+
+// bothAllowed returns the rule allowing the connections an egress rule and an ingress rule both allow,
+// false when no connection matches both
+func bothAllowed(egress firewallRule, ingress firewallRule) (firewallRule, bool) {
+	both := egress
+	both.Comment = egress.Comment + " and " + ingress.Comment
+	both.Rule.From.Zones = intersectZones(egress.Rule.From.Zones, ingress.Rule.From.Zones)
+	both.Rule.To.Zones = intersectZones(egress.Rule.To.Zones, ingress.Rule.To.Zones)
+
+	var fromOK, toOK, servicesOK bool
+	both.From, fromOK = intersectAddresses(egress.From, ingress.From)
+	both.To, toOK = intersectAddresses(egress.To, ingress.To)
+	both.Services, servicesOK = intersectServices(egress.Services, ingress.Services)
+	ok := fromOK && toOK && servicesOK && len(both.Rule.From.Zones) > 0 && len(both.Rule.To.Zones) > 0
+	return both, ok
+}
+
+// isolatedDeny returns the rule dropping the connections from the pods isolated for egress to the pods isolated for
+// ingress, once the connections both sides allow are accepted, false when no pod is isolated on one of the sides
+func isolatedDeny(egressDenies []firewallRule, ingressDenies []firewallRule) (firewallRule, bool) {
+	if len(egressDenies) == 0 || len(ingressDenies) == 0 {
+		return firewallRule{}, false
+	}
+
+	deny := firewallRule{Rule: FirewallRule{Action: ActionDeny}, Comment: "pods isolated for egress to pods isolated for ingress DENY"}
+	var fromZones, toZones []string
+	for _, rule := range egressDenies {
+		deny.From = append(deny.From, rule.From...)
+		fromZones = append(fromZones, rule.Rule.From.Zones...)
+	}
+	for _, rule := range ingressDenies {
+		deny.To = append(deny.To, rule.To...)
+		toZones = append(toZones, rule.Rule.To.Zones...)
+	}
+	deny.From, deny.To = uniqueAddresses(deny.From), uniqueAddresses(deny.To)
+	deny.Rule.From.Zones, deny.Rule.To.Zones = uniqueSorted(fromZones), uniqueSorted(toZones)
+	return deny, true
+}
+
+// intersectAddresses returns the addresses in both lists, nil stands for any, false when no address is in both
+func intersectAddresses(a []addressObject, b []addressObject) ([]addressObject, bool) {
+	switch {
+	case a == nil:
+		return b, true
+	case b == nil:
+		return a, true
+	}
+
+	var both []addressObject
+	for _, x := range a {
+		for _, y := range b {
+			switch {
+			case covers(y.Network, x.Network):
+				both = append(both, x)
+			case covers(x.Network, y.Network):
+				both = append(both, y)
+			}
+		}
+	}
+	both = uniqueAddresses(both)
+	return both, len(both) > 0
+}
+
+// covers reports if a network holds another one
+func covers(outer *net.IPNet, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// uniqueAddresses returns the addresses without repeated objects
+func uniqueAddresses(addresses []addressObject) []addressObject {
+	seen := map[string]bool{}
+	var unique []addressObject
+	for _, address := range addresses {
+		if !seen[address.Name] {
+			seen[address.Name] = true
+			unique = append(unique, address)
+		}
+	}
+	return unique
+}
+
+// intersectServices returns the services in both lists, nil stands for all ports, false when no port is in both
+// a service of a whole protocol holds every port of the protocol
+func intersectServices(a []serviceObject, b []serviceObject) ([]serviceObject, bool) {
+	switch {
+	case a == nil:
+		return b, true
+	case b == nil:
+		return a, true
+	}
+
+	seen := map[string]bool{}
+	var both []serviceObject
+	for _, x := range a {
+		for _, y := range b {
+			if x.Protocol != y.Protocol || (x.Port != 0 && y.Port != 0 && x.Port != y.Port) {
+				continue
+			}
+			service := x
+			if x.Port == 0 {
+				service = y
+			}
+			if !seen[service.Name] {
+				seen[service.Name] = true
+				both = append(both, service)
+			}
+		}
+	}
+	return both, len(both) > 0
+}
+
+// intersectZones returns the zones in both lists
+func intersectZones(a []string, b []string) []string {
+	var both []string
+	for _, zone := range a {
+		for _, other := range b {
+			if zone == other {
+				both = append(both, zone)
+			}
+		}
+	}
+	return uniqueSorted(both)
+}
+
+// addressObjects returns the objects of a list of addresses and CIDRs
+func addressObjects(addresses []string) []addressObject {
+	var objects []addressObject
+	for _, address := range addresses {
+		if !strings.Contains(address, "/") {
+			if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+				address += "/32"
+			} else {
+				address += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			continue
+		}
+		name := "net-" + strings.NewReplacer("/", "_", ":", ".").Replace(network.String())
+		object := addressObject{Name: name, Network: network}
+		if object.Host() {
+			object.Name = "host-" + strings.Replace(network.IP.String(), ":", ".", -1)
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// serviceObjects returns the objects of the numeric ports of a location, nil for all ports
+func serviceObjects(location FirewallLocation) []serviceObject {
+	if location.AllPorts || len(location.Ports) == 0 {
+		return nil
+	}
+	var objects []serviceObject
+	for _, port := range location.Ports {
+		protocol := strings.ToLower(string(portProtocol(port)))
+		switch {
+		case port.Port == nil:
+			objects = append(objects, serviceObject{Name: protocol + "-all", Protocol: protocol})
+		case port.Port.Type == intstr.Int:
+			objects = append(objects, serviceObject{Name: fmt.Sprintf("%s-%d", protocol, port.Port.IntVal), Protocol: protocol, Port: int(port.Port.IntVal)})
+		}
+	}
+	return objects
+}
+
+// objectNames returns the names of firewall objects, or the given any name when there is none
+func objectNames(addresses []addressObject, anyName string) []string {
+	if len(addresses) == 0 {
+		return []string{anyName}
+	}
+	var names []string
+	for _, address := range addresses {
+		names = append(names, address.Name)
+	}
+	return names
+}
+
+// serviceNames returns the names of service objects, or the given any name for all ports
+func serviceNames(services []serviceObject, anyName string) []string {
+	if len(services) == 0 {
+		return []string{anyName}
+	}
+	var names []string
+	for _, service := range services {
+		names = append(names, service.Name)
+	}
+	return names
+}
+
+// directionComment returns the comment of a firewall rule with the side of the policy it comes from
+func directionComment(rule FirewallRule) string {
+	return fmt.Sprintf("%s %s", ruleComment(rule), strings.ToLower(string(rule.Direction)))
+}
+
+// panosExporter writes the rules as PAN-OS set commands
+type panosExporter struct{}
+
+func (panosExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	rulebase := newFirewallRulebase(policies)
+
+	fmt.Fprintln(w, "# Generated by NetworkPolicyExporter, zones must exist on the firewall")
+	for _, address := range rulebase.Addresses {
+		fmt.Fprintf(w, "set address %s ip-netmask %s\n", address.Name, address.Network)
+	}
+	for _, service := range rulebase.Services {
+		port := "1-65535"
+		if service.Port != 0 {
+			port = fmt.Sprint(service.Port)
+		}
+		fmt.Fprintf(w, "set service %s protocol %s port %s\n", service.Name, service.Protocol, port)
+	}
+
+	for _, r := range rulebase.Skipped {
+		fmt.Fprintf(w, "# skipped %s: %s\n", ruleComment(r.Rule), r.Skip)
+	}
+	for i, r := range rulebase.Rules {
+		if i == 0 || !sameZonePair(r, rulebase.Rules[i-1]) {
+			fmt.Fprintf(w, "# zone %s to zone %s\n", r.FromZone, r.ToZone)
+		}
+		action := "allow"
+		switch r.Rule.Action {
+		case ActionReject:
+			action = "reset-both"
+		case ActionDeny:
+			action = "drop"
+		}
+		fmt.Fprintf(w, "set rulebase security rules %s from %s to %s source %s destination %s application any service %s action %s description \"%s\"\n",
+			r.Name, r.FromZone, r.ToZone,
+			panosMembers(objectNames(r.From, "any")), panosMembers(objectNames(r.To, "any")),
+			panosMembers(serviceNames(r.Services, "any")), action, r.Comment)
+	}
+	return nil
+}
+
+// panosMembers formats a list of members, brackets are only needed for several members
+func panosMembers(members []string) string {
+	if len(members) == 1 {
+		return members[0]
+	}
+	return "[ " + strings.Join(members, " ") + " ]"
+}
+
+// sameZonePair reports if two rules are in the same zone pair
+func sameZonePair(a firewallRule, b firewallRule) bool {
+	return a.FromZone == b.FromZone && a.ToZone == b.ToZone
+}
+
+// asaExporter writes the rules as Cisco ASA objects and one access-list per source zone bound to its interface
+type asaExporter struct{}
+
+func (asaExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	rulebase := newFirewallRulebase(policies)
+
+	fmt.Fprintln(w, "! Generated by NetworkPolicyExporter")
+	for _, address := range rulebase.Addresses {
+		fmt.Fprintf(w, "object network %s\n", address.Name)
+		switch {
+		case address.Host():
+			fmt.Fprintf(w, " host %s\n", address.Network.IP)
+		case address.IPv4():
+			fmt.Fprintf(w, " subnet %s %s\n", address.Network.IP, net.IP(address.Network.Mask))
+		default:
+			fmt.Fprintf(w, " subnet %s\n", address.Network)
+		}
+	}
+	for _, service := range rulebase.Services {
+		fmt.Fprintf(w, "object service %s\n", service.Name)
+		if service.Port == 0 {
+			fmt.Fprintf(w, " service %s\n", service.Protocol)
+		} else {
+			fmt.Fprintf(w, " service %s destination eq %d\n", service.Protocol, service.Port)
+		}
+	}
+
+	for _, r := range rulebase.Skipped {
+		fmt.Fprintf(w, "! skipped %s: %s\n", ruleComment(r.Rule), r.Skip)
+	}
+
+	// the operands of a rule are shared by all its zone pairs
+	operands := map[int][]string{}
+	var zones []string
+	entries := map[string][]string{}
+	for i, r := range rulebase.Rules {
+		if operands[r.Position] == nil {
+			name := fmt.Sprintf("nsm-%d", r.Position)
+			service := asaGroup(w, "service", name+"-services", serviceNames(r.Services, ""), "service-object object")
+			if service == "any" {
+				service = "ip"
+			}
+			operands[r.Position] = []string{
+				service,
+				asaGroup(w, "network", name+"-from", objectNames(r.From, ""), "network-object object"),
+				asaGroup(w, "network", name+"-to", objectNames(r.To, ""), "network-object object"),
+			}
+		}
+
+		action := "permit"
+		if r.Rule.Action != ActionAllow {
+			action = "deny"
+		}
+		list := "nsm-" + r.FromZone
+		if _, ok := entries[list]; !ok {
+			zones = append(zones, r.FromZone)
+		}
+		if i == 0 || !sameZonePair(r, rulebase.Rules[i-1]) {
+			entries[list] = append(entries[list], fmt.Sprintf("access-list %s remark zone %s to zone %s", list, r.FromZone, r.ToZone))
+		}
+		entries[list] = append(entries[list],
+			fmt.Sprintf("access-list %s remark %s", list, r.Comment),
+			fmt.Sprintf("access-list %s extended %s %s", list, action, strings.Join(operands[r.Position], " ")))
+	}
+
+	for _, zone := range zones {
+		list := "nsm-" + zone
+		for _, entry := range entries[list] {
+			fmt.Fprintln(w, entry)
+		}
+		fmt.Fprintf(w, "access-group %s in interface %s\n", list, zone)
+	}
+	return nil
+}
+
+// asaGroup returns the access-list operand of a list of object names, declaring an object-group for several objects
+func asaGroup(w io.Writer, kind string, name string, objects []string, member string) string {
+	switch {
+	case len(objects) == 1 && objects[0] == "":
+		return "any"
+	case len(objects) == 1:
+		return "object " + objects[0]
+	}
+	fmt.Fprintf(w, "object-group %s %s\n", kind, name)
+	for _, object := range objects {
+		fmt.Fprintf(w, " %s %s\n", member, object)
+	}
+	return "object-group " + name
+}
+
+// fortiosExporter writes the rules as FortiOS configuration blocks, zones are used as interfaces
+// only IPv4 addresses are written
+type fortiosExporter struct{}
+
+func (fortiosExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	rulebase := newFirewallRulebase(policies)
+
+	fmt.Fprintln(w, "# Generated by NetworkPolicyExporter")
+	fmt.Fprintln(w, "config firewall address")
+	for _, address := range rulebase.Addresses {
+		if !address.IPv4() {
+			continue
+		}
+		fmt.Fprintf(w, "    edit \"%s\"\n", address.Name)
+		fmt.Fprintf(w, "        set subnet %s %s\n", address.Network.IP, net.IP(address.Network.Mask))
+		fmt.Fprintln(w, "    next")
+	}
+	fmt.Fprintln(w, "end")
+	fmt.Fprintln(w, "config firewall address6")
+	for _, address := range rulebase.Addresses {
+		if address.IPv4() {
+			continue
+		}
+		fmt.Fprintf(w, "    edit \"%s\"\n", address.Name)
+		fmt.Fprintf(w, "        set ip6 %s\n", address.Network)
+		fmt.Fprintln(w, "    next")
+	}
+	fmt.Fprintln(w, "end")
+
+	fmt.Fprintln(w, "config firewall service custom")
+	for _, service := range rulebase.Services {
+		ports := "1-65535"
+		if service.Port != 0 {
+			ports = fmt.Sprint(service.Port)
+		}
+		fmt.Fprintf(w, "    edit \"%s\"\n", service.Name)
+		switch service.Protocol {
+		case "tcp", "udp", "sctp":
+			fmt.Fprintf(w, "        set %s-portrange %s\n", service.Protocol, ports)
+		}
+		fmt.Fprintln(w, "    next")
+	}
+	fmt.Fprintln(w, "end")
+
+	fmt.Fprintln(w, "config firewall policy")
+	for _, r := range rulebase.Skipped {
+		fmt.Fprintf(w, "    # skipped %s: %s\n", ruleComment(r.Rule), r.Skip)
+	}
+	for _, r := range rulebase.Rules {
+		from, to := fortiosAddresses(r.From, true), fortiosAddresses(r.To, true)
+		from6, to6 := fortiosAddresses(r.From, false), fortiosAddresses(r.To, false)
+		ipv4 := len(from) > 0 && len(to) > 0
+		ipv6 := len(from6) > 0 && len(to6) > 0
+		if !ipv4 && !ipv6 {
+			fmt.Fprintf(w, "    # skipped %s: no source and destination of the same family\n", r.Comment)
+			continue
+		}
+
+		fmt.Fprintln(w, "    edit 0")
+		fmt.Fprintf(w, "        set name \"%s\"\n", r.Name)
+		fmt.Fprintf(w, "        set srcintf %s\n", fortiosQuote([]string{r.FromZone}))
+		fmt.Fprintf(w, "        set dstintf %s\n", fortiosQuote([]string{r.ToZone}))
+		if ipv4 {
+			fmt.Fprintf(w, "        set srcaddr %s\n", fortiosQuote(from))
+			fmt.Fprintf(w, "        set dstaddr %s\n", fortiosQuote(to))
+		}
+		if ipv6 {
+			fmt.Fprintf(w, "        set srcaddr6 %s\n", fortiosQuote(from6))
+			fmt.Fprintf(w, "        set dstaddr6 %s\n", fortiosQuote(to6))
+		}
+		fmt.Fprintf(w, "        set service %s\n", fortiosQuote(serviceNames(r.Services, "ALL")))
+		fmt.Fprintln(w, "        set schedule \"always\"")
+		switch r.Rule.Action {
+		case ActionAllow:
+			fmt.Fprintln(w, "        set action accept")
+		case ActionReject:
+			fmt.Fprintln(w, "        set action deny")
+			fmt.Fprintln(w, "        set send-deny-packet enable")
+		default:
+			fmt.Fprintln(w, "        set action deny")
+		}
+		fmt.Fprintf(w, "        set comments \"%s\"\n", r.Comment)
+		fmt.Fprintln(w, "    next")
+	}
+	_, err := fmt.Fprintln(w, "end")
+	return err
+}
+
+// fortiosAddresses returns the names of the IPv4 or IPv6 objects of an endpoint, all for any address
+func fortiosAddresses(addresses []addressObject, ipv4 bool) []string {
+	if len(addresses) == 0 {
+		return []string{"all"}
+	}
+	var names []string
+	for _, address := range addresses {
+		if address.IPv4() == ipv4 {
+			names = append(names, address.Name)
+		}
+	}
+	return names
+}
+
+// fortiosQuote quotes a list of names
+func fortiosQuote(names []string) string {
+	return "\"" + strings.Join(names, "\" \"") + "\""
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FormatLocation flattens a location into a single string
+// pods are written namespace/pods with * for all namespaces or all pods and {selector} for namespace selectors
+func FormatLocation(location FirewallLocation) string {
+	switch {
+	case location.Any:
+		return "any"
+	case location.DNSName != "":
+		return location.DNSName
+	case location.CIDR != "":
+		if len(location.Except) == 0 {
+			return location.CIDR
+		}
+		return location.CIDR + " except " + strings.Join(location.Except, ",")
+	}
+
+	return formatNamespace(location) + "/" + formatSelector(location.PodSelector)
+}
+
+// formatNamespace formats the namespaces of a selector location, * for all namespaces and {selector} for a namespace selector
+func formatNamespace(location FirewallLocation) string {
+	switch {
+	case location.AllNamespaces:
+		return "*"
+	case location.NamespaceSelector != nil:
+		return "{" + formatSelector(location.NamespaceSelector) + "}"
+	}
+	return location.Namespace
+}
+
+// formatSelector formats a label selector, * when it selects everything
+func formatSelector(selector *metav1.LabelSelector) string {
+	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
+		return "*"
+	}
+	return metav1.FormatLabelSelector(selector)
+}
+
+// FormatPorts flattens the ports of a location as protocol/port keys and its port ranges, ANY for all ports
+func FormatPorts(location FirewallLocation) string {
+	if location.AllPorts || (len(location.Ports) == 0 && len(location.PortRanges) == 0) {
+		return "ANY"
+	}
+	var keys []string
+	for _, port := range location.Ports {
+		keys = append(keys, portKey(port))
+	}
+	return strings.Join(append(keys, location.PortRanges...), ",")
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+// Checks of the reverse translation, on the rules NetworkPolicy cannot express
+const (
+	CheckUnsupportedTarget = "unsupported-target"
+	CheckUnexpressedDeny   = "unexpressed-deny"
+	CheckUnexpressedReject = "unexpressed-reject"
+	CheckUnsupportedKind   = "unsupported-kind"
+	CheckNamespaceName     = "namespace-name-label"
+	CheckNamespaceLabels   = "namespace-labels"
+)
+
+// importedPolicyPrefix prefixes the names of the policies generated for a target without a single source policy
+const importedPolicyPrefix = "nsm-"
+
+// LoadFirewallRules reads firewall rules from the exporter JSON format, or from CSV when the file ends in .csv
+// CSV files need direction, action, from and to columns, with optional ports, namespace, policy and order ones,
+// the exporter CSV is read as is, locations are written like the exporter writes them or name a zone
+func LoadFirewallRules(path string, zones *ZoneConfig) ([]FirewallPolicy, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		policies, err := readRuleCSV(strings.NewReader(string(data)), zones)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return policies, nil
+	}
+
+	// the JSON output of -merged is the plain array of the rules of the merged rulebase
+	var rules []FirewallRule
+	if json.Unmarshal(data, &rules) == nil && len(rules) > 0 && rules[0].Action != "" {
+		return []FirewallPolicy{{Name: mergedRulebaseName, Rules: rules}}, nil
+	}
+
+	var policies []FirewallPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return policies, nil
+}
+
+// readRuleCSV reads one rule per line, the rules of a line naming zones are expanded to every namespace and CIDR of the zones
+func readRuleCSV(r io.Reader, zones *ZoneConfig) ([]FirewallPolicy, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"direction", "action", "from", "to"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var policies []FirewallPolicy
+	index := map[PolicyReference]int{}
+	for line, record := range records[1:] {
+		rules, err := csvRules(field, record, line, zones)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line+2, err)
+		}
+		for _, rule := range rules {
+			if _, ok := index[rule.Policy]; !ok {
+				index[rule.Policy] = len(policies)
+				policies = append(policies, FirewallPolicy{Namespace: rule.Policy.Namespace, Name: rule.Policy.Name})
+			}
+			policy := &policies[index[rule.Policy]]
+			policy.Rules = append(policy.Rules, rule)
+		}
+	}
+	return policies, nil
+}
+
+// csvRules returns the rules of a CSV line, the order is the line number without an order column
+func csvRules(field func([]string, string) string, record []string, line int, zones *ZoneConfig) ([]FirewallRule, error) {
+	var direction netv1.PolicyType
+	switch strings.ToLower(field(record, "direction")) {
+	case "ingress":
+		direction = netv1.PolicyTypeIngress
+	case "egress":
+		direction = netv1.PolicyTypeEgress
+	default:
+		return nil, fmt.Errorf("unknown direction %q, use Ingress or Egress", field(record, "direction"))
+	}
+
+	action := strings.ToUpper(field(record, "action"))
+	if action != ActionAllow && action != ActionReject && action != ActionDeny {
+		return nil, fmt.Errorf("unknown action %q, use %s, %s or %s", action, ActionAllow, ActionReject, ActionDeny)
+	}
+
+	order := line
+	if value := field(record, "order"); value != "" {
+		var err error
+		if order, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid order %q", value)
+		}
+	}
+
+	froms, err := parseLocation(field(record, "from"), zones)
+	if err != nil {
+		return nil, err
+	}
+	tos, err := parseLocation(field(record, "to"), zones)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := parsePorts(field(record, "ports"))
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []FirewallRule
+	for _, from := range froms {
+		for _, to := range tos {
+			rule := FirewallRule{From: from, To: to, Action: action, Order: order, Direction: direction}
+			*rule.PortsLocation() = portsLocation(*rule.PortsLocation(), ports)
+			rule.Policy = PolicyReference{Namespace: field(record, "namespace"), Name: field(record, "policy")}
+			if rule.Policy.Namespace == "" {
+				rule.Policy.Namespace = targetLocation(rule).Namespace
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// parseLocation parses a location written like FormatLocation writes it, or the name of a zone
+func parseLocation(value string, zones *ZoneConfig) ([]FirewallLocation, error) {
+	switch {
+	case value == "" || strings.EqualFold(value, "any"):
+		return []FirewallLocation{{Any: true}}, nil
+	case strings.Contains(value, " except "):
+		parts := strings.SplitN(value, " except ", 2)
+		location := FirewallLocation{CIDR: strings.TrimSpace(parts[0]), Except: strings.Split(strings.TrimSpace(parts[1]), ",")}
+		for _, cidr := range append([]string{location.CIDR}, location.Except...) {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return nil, err
+			}
+		}
+		return []FirewallLocation{location}, nil
+	case net.ParseIP(value) != nil:
+		bits := 32
+		if net.ParseIP(value).To4() == nil {
+			bits = 128
+		}
+		return []FirewallLocation{{CIDR: fmt.Sprintf("%s/%d", value, bits)}}, nil
+	}
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return []FirewallLocation{{CIDR: value}}, nil
+	}
+
+	if strings.HasPrefix(value, "{") {
+		end := strings.Index(value, "}/")
+		if end < 0 {
+			return nil, fmt.Errorf("invalid location %q, use {namespace selector}/pod selector", value)
+		}
+		namespaceSelector, err := metav1.ParseToLabelSelector(value[1:end])
+		if err != nil {
+			return nil, err
+		}
+		podSelector, err := parsePodSelector(value[end+2:])
+		if err != nil {
+			return nil, err
+		}
+		return []FirewallLocation{{NamespaceSelector: namespaceSelector, PodSelector: podSelector}}, nil
+	}
+	if slash := strings.Index(value, "/"); slash > 0 {
+		podSelector, err := parsePodSelector(value[slash+1:])
+		if err != nil {
+			return nil, err
+		}
+		location := FirewallLocation{Namespace: value[:slash], PodSelector: podSelector}
+		if location.Namespace == "*" {
+			location.Namespace, location.AllNamespaces = "", true
+		}
+		return []FirewallLocation{location}, nil
+	}
+
+	if zones != nil {
+		for _, zone := range zones.Zones {
+			if zone.Name != value {
+				continue
+			}
+			var locations []FirewallLocation
+			for _, namespace := range zone.Namespaces {
+				locations = append(locations, FirewallLocation{Namespace: namespace, PodSelector: &metav1.LabelSelector{}})
+			}
+			if zone.NamespaceSelector != nil {
+				locations = append(locations, FirewallLocation{NamespaceSelector: zone.NamespaceSelector})
+			}
+			for _, cidr := range append(append([]string{}, zone.NodeSubnets...), zone.CIDRs...) {
+				locations = append(locations, FirewallLocation{CIDR: cidr})
+			}
+			return locations, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown location %q, use any, a CIDR, namespace/pod selector or a zone", value)
+}
+
+// parsePodSelector parses a pod selector, * selects every pod
+func parsePodSelector(value string) (*metav1.LabelSelector, error) {
+	if value == "*" || value == "" {
+		return &metav1.LabelSelector{}, nil
+	}
+	return metav1.ParseToLabelSelector(value)
+}
+
+// parsePorts parses protocol/port keys separated by commas or spaces, a port alone is TCP, ANY or nothing is all ports
+func parsePorts(value string) ([]netv1.NetworkPolicyPort, error) {
+	var ports []netv1.NetworkPolicyPort
+	for _, key := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if strings.EqualFold(key, "ANY") {
+			return nil, nil
+		}
+		protocol, port := corev1.ProtocolTCP, key
+		if parts := strings.SplitN(key, "/", 2); len(parts) == 2 {
+			protocol, port = corev1.Protocol(strings.ToUpper(parts[0])), parts[1]
+		} else if _, err := strconv.Atoi(key); err != nil {
+			protocol, port = corev1.Protocol(strings.ToUpper(key)), ""
+		}
+		if protocol != corev1.ProtocolTCP && protocol != corev1.ProtocolUDP && protocol != corev1.ProtocolSCTP {
+			return nil, fmt.Errorf("unknown protocol in port %q", key)
+		}
+		policyPort := netv1.NetworkPolicyPort{Protocol: &protocol}
+		if port != "" {
+			parsed := intstr.Parse(port)
+			policyPort.Port = &parsed
+		}
+		ports = append(ports, policyPort)
+	}
+	return ports, nil
+}
+
+// reverseGroup collects the rules of the pods one generated policy selects
+type reverseGroup struct {
+	namespace string
+	selector  *metav1.LabelSelector
+	sources   []string
+	allows    map[netv1.PolicyType][]FirewallRule
+	others    []FirewallRule
+}
+
+// ReverseTranslate synthesizes the NetworkPolicies of firewall rules, one per namespace and target pod selector
+// an allowed CIDR keeps its except blocks and takes the rejected CIDRs inside it as more, a deny of any peer isolates
+// the rules NetworkPolicy cannot express, other denies and rejects or targets outside a namespace, are returned as findings
+// peers in other namespaces select them by their name label, or with an inventory, for clusters older than Kubernetes 1.21,
+// by their own labels when the inventory tells they select them alone
+func ReverseTranslate(policies []FirewallPolicy, inventory *Inventory) ([]netv1.NetworkPolicy, []Finding) {
+	var groups []*reverseGroup
+	index := map[string]*reverseGroup{}
+	var findings []Finding
+
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			if rule.Policy.Kind != "" {
+				findings = append(findings, newFinding(SeverityHigh, CheckUnsupportedKind, rule,
+					fmt.Sprintf("%s rules apply in order around network policies, NetworkPolicy cannot express them", rule.Policy.Kind)))
+				continue
+			}
+			target := targetLocation(rule)
+			if target.Any || target.CIDR != "" || target.AllNamespaces || target.NamespaceSelector != nil || target.Namespace == "" {
+				severity := SeverityMedium
+				if rule.Action != ActionAllow {
+					severity = SeverityHigh
+				}
+				findings = append(findings, newFinding(severity, CheckUnsupportedTarget, rule,
+					fmt.Sprintf("%s applies to %s, a policy only selects pods of its namespace", rule.Action, FormatLocation(target))))
+				continue
+			}
+
+			key := target.Namespace + "/" + formatSelector(target.PodSelector)
+			group := index[key]
+			if group == nil {
+				group = &reverseGroup{namespace: target.Namespace, selector: target.PodSelector, allows: map[netv1.PolicyType][]FirewallRule{}}
+				if group.selector == nil {
+					group.selector = &metav1.LabelSelector{}
+				}
+				index[key] = group
+				groups = append(groups, group)
+			}
+			if rule.Policy.Name != "" && rule.Policy.Name != mergedRulebaseName {
+				group.sources = uniqueSorted(append(group.sources, rule.Policy.Name))
+			}
+			if _, ok := group.allows[rule.Direction]; !ok {
+				group.allows[rule.Direction] = nil
+			}
+			if rule.Action == ActionAllow {
+				group.allows[rule.Direction] = append(group.allows[rule.Direction], rule)
+			} else {
+				group.others = append(group.others, rule)
+			}
+		}
+	}
+
+	var items []netv1.NetworkPolicy
+	names := map[string]bool{}
+	for _, group := range groups {
+		for _, rule := range group.others {
+			if finding, ok := group.absorb(rule); !ok {
+				findings = append(findings, finding)
+			}
+		}
+
+		policy := netv1.NetworkPolicy{
+			TypeMeta:   metav1.TypeMeta{APIVersion: netv1.SchemeGroupVersion.String(), Kind: "NetworkPolicy"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: group.namespace, Name: group.name(names)},
+			Spec:       netv1.NetworkPolicySpec{PodSelector: *group.selector},
+		}
+		for _, direction := range []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress} {
+			rules, ok := group.allows[direction]
+			if !ok {
+				continue
+			}
+			policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, direction)
+			peerRules, peerFindings := reversePeers(rules, group.namespace, inventory)
+			findings = append(findings, peerFindings...)
+			for _, peers := range peerRules {
+				if direction == netv1.PolicyTypeIngress {
+					policy.Spec.Ingress = append(policy.Spec.Ingress, netv1.NetworkPolicyIngressRule{Ports: peers.ports, From: peers.peers})
+				} else {
+					policy.Spec.Egress = append(policy.Spec.Egress, netv1.NetworkPolicyEgressRule{Ports: peers.ports, To: peers.peers})
+				}
+			}
+		}
+		items = append(items, policy)
+	}
+
+	sortFindings(findings)
+	return items, findings
+}
+
+// absorb expresses a deny or reject rule in the allowed rules of the group, or returns why it cannot be
+// a deny of any peer on all ports is the isolation of the policy, a rejected CIDR inside an allowed one becomes an except block
+func (g *reverseGroup) absorb(rule FirewallRule) (Finding, bool) {
+	peer := peerLocation(rule)
+	if peer.Any && rulePorts(rule).All {
+		return Finding{}, true
+	}
+
+	if rule.Action == ActionReject && peer.CIDR != "" {
+		allows := g.allows[rule.Direction]
+		for i, allow := range allows {
+			allowed := peerLocation(allow)
+			if allowed.CIDR == "" || !cidrInside(peer.CIDR, allowed.CIDR) {
+				continue
+			}
+			excepted := false
+			for _, except := range allowed.Except {
+				excepted = excepted || cidrInside(peer.CIDR, except)
+			}
+			if excepted {
+				return Finding{}, true
+			}
+			if portsCover(rulePorts(rule), rulePorts(allow)) {
+				location := allows[i].PortsLocation()
+				location.Except = append(append([]string{}, location.Except...), peer.CIDR)
+				return Finding{}, true
+			}
+			return newFinding(SeverityHigh, CheckUnexpressedReject, rule,
+				fmt.Sprintf("rejects %s of %s, the except block of %s would reject %s", FormatPorts(peer), peer.CIDR, allowed.CIDR, FormatPorts(allowed))), false
+		}
+	}
+
+	check := CheckUnexpressedDeny
+	if rule.Action == ActionReject {
+		check = CheckUnexpressedReject
+	}
+	for _, allow := range g.allows[rule.Direction] {
+		if peersOverlap(peerLocation(allow), peer) {
+			return newFinding(SeverityHigh, check, rule,
+				fmt.Sprintf("%s %s from %s to %s, NetworkPolicy cannot, %s allows it", strings.ToLower(rule.Action), FormatPorts(peer), FormatLocation(rule.From), FormatLocation(rule.To), importedRuleName(allow))), false
+		}
+	}
+	return newFinding(SeverityLow, check, rule,
+		fmt.Sprintf("%s %s from %s to %s, left to the isolation of the policy", strings.ToLower(rule.Action), FormatPorts(peer), FormatLocation(rule.From), FormatLocation(rule.To))), false
+}
+
+// importedRuleName names a rule by its policy when it has one and its order
+func importedRuleName(rule FirewallRule) string {
+	if rule.Policy.Name == "" {
+		return fmt.Sprintf("rule %d", rule.Order)
+	}
+	return fmt.Sprintf("%s rule %d", rule.Policy, rule.Order)
+}
+
+// peersOverlap reports if two peer locations may hold the same pod or address, selectors are assumed to overlap
+func peersOverlap(a FirewallLocation, b FirewallLocation) bool {
+	switch {
+	case a.Any || b.Any:
+		return true
+	case a.CIDR != "" && b.CIDR != "":
+		return cidrOverlaps(a.CIDR, b.CIDR)
+	}
+	return a.CIDR == "" && b.CIDR == ""
+}
+
+// invalidNameCharacters are the characters a policy name cannot hold
+var invalidNameCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// name returns the name of the source policy when there is one, or a name made of the pod selector, unique in the namespace
+func (g *reverseGroup) name(names map[string]bool) string {
+	base := importedPolicyPrefix + "all-pods"
+	switch {
+	case len(g.sources) == 1:
+		base = g.sources[0]
+	case formatSelector(g.selector) != "*":
+		base = importedPolicyPrefix + strings.Trim(invalidNameCharacters.ReplaceAllString(strings.ToLower(formatSelector(g.selector)), "-"), "-")
+	}
+	if len(base) > 58 {
+		base = strings.TrimRight(base[:58], "-")
+	}
+
+	name := base
+	for i := 2; names[g.namespace+"/"+name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	names[g.namespace+"/"+name] = true
+	return name
+}
+
+// reversePeer is a rule of a generated policy, the peers allowed on the same ports
+type reversePeer struct {
+	ports []netv1.NetworkPolicyPort
+	peers []netv1.NetworkPolicyPeer
+}
+
+// reversePeers merges allowed rules with the same ports into policy rules, a rule allowing any peer has no peer
+// with an inventory the rules selecting another namespace are returned as findings, by its labels or its name label
+func reversePeers(rules []FirewallRule, namespace string, inventory *Inventory) ([]reversePeer, []Finding) {
+	var merged []reversePeer
+	var findings []Finding
+	index := map[string]int{}
+	anyPeer := map[string]bool{}
+	for _, rule := range rules {
+		peer := peerLocation(rule)
+		key := FormatPorts(peer)
+		if _, ok := index[key]; !ok {
+			index[key] = len(merged)
+			merged = append(merged, reversePeer{ports: peer.Ports})
+			if peer.AllPorts {
+				merged[index[key]].ports = nil
+			}
+		}
+		if anyPeer[key] {
+			continue
+		}
+		if peer.Any {
+			anyPeer[key] = true
+			merged[index[key]].peers = nil
+			continue
+		}
+		policyPeer, otherNamespace := locationPeer(peer, namespace)
+		if otherNamespace && inventory != nil {
+			var byName bool
+			policyPeer.NamespaceSelector, byName = namespaceSelector(peer.Namespace, inventory)
+			if byName {
+				findings = append(findings, newFinding(SeverityLow, CheckNamespaceName, rule,
+					fmt.Sprintf("selects namespace %s by its %s label, set from Kubernetes 1.21, no other label selects it alone, label the namespace on older clusters", peer.Namespace, namespaceNameLabel)))
+			} else {
+				findings = append(findings, newFinding(SeverityLow, CheckNamespaceLabels, rule,
+					fmt.Sprintf("selects namespace %s by its labels %s, an approximation also selecting the namespaces labelled alike later", peer.Namespace, labels.Set(policyPeer.NamespaceSelector.MatchLabels))))
+			}
+		}
+		merged[index[key]].peers = append(merged[index[key]].peers, policyPeer)
+	}
+	return merged, findings
+}
+
+// locationPeer returns the policy peer of a location seen from a policy namespace,
+// true when it selects another namespace by its name label
+func locationPeer(location FirewallLocation, namespace string) (netv1.NetworkPolicyPeer, bool) {
+	if location.CIDR != "" {
+		return netv1.NetworkPolicyPeer{IPBlock: &netv1.IPBlock{CIDR: location.CIDR, Except: location.Except}}, false
+	}
+
+	otherNamespace := false
+
+	peer := netv1.NetworkPolicyPeer{PodSelector: location.PodSelector}
+	switch {
+	case location.AllNamespaces:
+		peer.NamespaceSelector = &metav1.LabelSelector{}
+	case location.NamespaceSelector != nil:
+		peer.NamespaceSelector = location.NamespaceSelector
+	case location.Namespace != namespace:
+		peer.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: location.Namespace}}
+		otherNamespace = true
+	}
+	if peer.NamespaceSelector == nil && peer.PodSelector == nil {
+		peer.PodSelector = &metav1.LabelSelector{}
+	}
+	if peer.NamespaceSelector != nil && formatSelector(peer.PodSelector) == "*" {
+		peer.PodSelector = nil
+	}
+	return peer, otherNamespace
+}
+
+// namespaceSelector returns the selector of a namespace by its own labels when no other namespace of the inventory has
+// them, or by the name label the API server only sets from Kubernetes 1.21, true in that case
+func namespaceSelector(name string, inventory *Inventory) (*metav1.LabelSelector, bool) {
+	byName := &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: name}}
+
+	own := labels.Set{}
+	for _, namespace := range inventory.Namespaces {
+		if namespace.Name == name {
+			for key, value := range namespace.Labels {
+				if key != namespaceNameLabel {
+					own[key] = value
+				}
+			}
+		}
+	}
+	if len(own) == 0 {
+		return byName, true
+	}
+	for _, namespace := range inventory.Namespaces {
+		if namespace.Name != name && labels.SelectorFromSet(own).Matches(labels.Set(namespace.Labels)) {
+			return byName, true
+		}
+	}
+	return &metav1.LabelSelector{MatchLabels: own}, false
+}
+
+// WriteNetworkPolicies writes policies as a multi-document YAML manifest
+func WriteNetworkPolicies(w io.Writer, items []netv1.NetworkPolicy) error {
+	for i, item := range items {
+		data, err := yaml.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
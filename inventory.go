@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	appsv1client "k8s.io/client-go/kubernetes/typed/apps/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// namespaceNameLabel is set by the API server on every namespace with the namespace name
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// templatePodAnnotation marks the pods built from a workload template
+const templatePodAnnotation = "network-security-manager/template"
+
+// Inventory holds the namespaces and pods that selectors are resolved against
+// workloads without pods are represented by a pod built from their template
+// an inventory on a secondary Network only holds the pods attached to it, with their addresses on it
+type Inventory struct {
+	Namespaces   []corev1.Namespace
+	Pods         []corev1.Pod
+	ReplicaSets  []appsv1.ReplicaSet
+	Deployments  []appsv1.Deployment
+	StatefulSets []appsv1.StatefulSet
+	DaemonSets   []appsv1.DaemonSet
+	Network      string `json:"-"`
+}
+
+// OnNetwork returns the inventory seen from a secondary network, the cluster network when empty
+func (inv *Inventory) OnNetwork(network string) *Inventory {
+	view := *inv
+	view.Network = network
+	return &view
+}
+
+// LoadInventory lists namespaces, pods and workloads through the typed clients,
+// a fake clientset can provide them as well
+func LoadInventory(core corev1client.CoreV1Interface, apps appsv1client.AppsV1Interface) (*Inventory, error) {
+	inventory := new(Inventory)
+
+	namespaces, err := core.Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	inventory.Namespaces = namespaces.Items
+
+	pods, err := core.Pods("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	inventory.Pods = pods.Items
+
+	replicaSets, err := apps.ReplicaSets("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	inventory.ReplicaSets = replicaSets.Items
+
+	deployments, err := apps.Deployments("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	inventory.Deployments = deployments.Items
+
+	statefulSets, err := apps.StatefulSets("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	inventory.StatefulSets = statefulSets.Items
+
+	daemonSets, err := apps.DaemonSets("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	inventory.DaemonSets = daemonSets.Items
+
+	return inventory, nil
+}
+
+// NamespaceLabels returns the labels of a namespace, including the name label set by the API server
+func (inv *Inventory) NamespaceLabels(name string) labels.Set {
+	set := labels.Set{}
+	for _, namespace := range inv.Namespaces {
+		if namespace.Name == name {
+			for key, value := range namespace.Labels {
+				set[key] = value
+			}
+		}
+	}
+	set[namespaceNameLabel] = name
+	return set
+}
+
+// NetworkPods returns the pods NetworkPolicies apply to, host network and completed pods are left out
+// on a secondary network, the pods not attached to it are left out too
+func (inv *Inventory) NetworkPods() []corev1.Pod {
+	var pods []corev1.Pod
+	candidates := append(append([]corev1.Pod{}, inv.Pods...), inv.templatePods()...)
+	for _, pod := range candidates {
+		if pod.Spec.HostNetwork || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, attached := podNetworks(pod)[inv.Network]; inv.Network != "" && !attached {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+// SelectPods returns the pods of a location scoped by namespace and pod selectors
+// any and CIDR locations are not resolved to pods
+func (inv *Inventory) SelectPods(location FirewallLocation) []corev1.Pod {
+	if !location.selectsPods() {
+		return nil
+	}
+
+	var pods []corev1.Pod
+	for _, pod := range inv.NetworkPods() {
+		if location.matchesPod(pod.Namespace, inv.NamespaceLabels(pod.Namespace), labels.Set(pod.Labels)) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+// PodOwner returns the kind and name of the workload owning a pod, following replicasets to their deployment
+func (inv *Inventory) PodOwner(pod corev1.Pod) (string, string) {
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return "", ""
+	}
+
+	if owner.Kind == "ReplicaSet" {
+		for _, replicaSet := range inv.ReplicaSets {
+			if replicaSet.Namespace != pod.Namespace || replicaSet.Name != owner.Name {
+				continue
+			}
+			if deployment := metav1.GetControllerOf(&replicaSet); deployment != nil {
+				return deployment.Kind, deployment.Name
+			}
+		}
+	}
+
+	return owner.Kind, owner.Name
+}
+
+// templatePods returns a pod built from the template of every workload without pods in the inventory
+func (inv *Inventory) templatePods() []corev1.Pod {
+	owned := map[string]bool{}
+	for _, pod := range inv.Pods {
+		kind, name := inv.PodOwner(pod)
+		owned[kind+"/"+pod.Namespace+"/"+name] = true
+	}
+
+	var pods []corev1.Pod
+	add := func(kind string, meta metav1.ObjectMeta, template corev1.PodTemplateSpec) {
+		if owned[kind+"/"+meta.Namespace+"/"+meta.Name] {
+			return
+		}
+		controller := true
+		pod := corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+		pod.Name = meta.Name
+		pod.Namespace = meta.Namespace
+		pod.Annotations = map[string]string{templatePodAnnotation: "true"}
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: kind, Name: meta.Name, Controller: &controller}}
+		pods = append(pods, pod)
+	}
+
+	for _, deployment := range inv.Deployments {
+		add("Deployment", deployment.ObjectMeta, deployment.Spec.Template)
+	}
+	for _, statefulSet := range inv.StatefulSets {
+		add("StatefulSet", statefulSet.ObjectMeta, statefulSet.Spec.Template)
+	}
+	for _, daemonSet := range inv.DaemonSets {
+		add("DaemonSet", daemonSet.ObjectMeta, daemonSet.Spec.Template)
+	}
+
+	return pods
+}
+
+// Workload returns the resolved workload of a pod
+func (inv *Inventory) Workload(pod corev1.Pod) Workload {
+	workload := Workload{Namespace: pod.Namespace, Pod: pod.Name, Template: pod.Annotations[templatePodAnnotation] == "true"}
+	workload.OwnerKind, workload.OwnerName = inv.PodOwner(pod)
+
+	if inv.Network != "" {
+		workload.IPs = podNetworks(pod)[inv.Network]
+		return workload
+	}
+	for _, ip := range pod.Status.PodIPs {
+		workload.IPs = append(workload.IPs, ip.IP)
+	}
+	if len(workload.IPs) == 0 && pod.Status.PodIP != "" {
+		workload.IPs = append(workload.IPs, pod.Status.PodIP)
+	}
+
+	return workload
+}
+
+// ResolveWorkloads annotates every rule endpoint selecting pods with the matching workloads
+func ResolveWorkloads(policies []FirewallPolicy, inventory *Inventory) {
+	for i := range policies {
+		for j := range policies[i].Rules {
+			rule := &policies[i].Rules[j]
+			rule.From.Workloads = inventory.OnNetwork(rule.From.Network).workloads(rule.From)
+			rule.To.Workloads = inventory.OnNetwork(rule.To.Network).workloads(rule.To)
+		}
+	}
+}
+
+// workloads returns the resolved workloads of a location
+func (inv *Inventory) workloads(location FirewallLocation) []Workload {
+	var workloads []Workload
+	for _, pod := range inv.SelectPods(location) {
+		workloads = append(workloads, inv.Workload(pod))
+	}
+	return workloads
+}
+
+// selectsPods reports if a location is made of pod and namespace selectors
+func (l FirewallLocation) selectsPods() bool {
+	return !l.Any && l.CIDR == "" && l.DNSName == ""
+}
+
+// matchesPod reports if a pod with the given namespace and labels is part of a selector location
+func (l FirewallLocation) matchesPod(namespace string, namespaceLabels labels.Set, podLabels labels.Set) bool {
+	if !l.selectsPods() {
+		return false
+	}
+
+	switch {
+	case l.Namespace != "":
+		if l.Namespace != namespace {
+			return false
+		}
+	case l.NamespaceSelector != nil:
+		if !selectorMatches(l.NamespaceSelector, namespaceLabels) {
+			return false
+		}
+	case !l.AllNamespaces:
+		return false
+	}
+
+	// a location without pod selector holds all the pods of its namespaces
+	return l.PodSelector == nil || selectorMatches(l.PodSelector, podLabels)
+}
+
+// selectorMatches reports if a label selector matches a set of labels, invalid selectors match nothing
+func selectorMatches(selector *metav1.LabelSelector, set labels.Set) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(set)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolveWorkloadsFromClientset(t *testing.T) {
+	controller := true
+	ownedBy := func(kind string, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+	}
+	web := map[string]string{"app": "web"}
+
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"team": "a"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "db"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-1", Labels: web, OwnerReferences: ownedBy("ReplicaSet", "web-5d4")},
+			Status:     corev1.PodStatus{PodIP: "10.128.0.10"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "debug", Labels: map[string]string{"app": "debug"}},
+			Status:     corev1.PodStatus{PodIP: "10.128.0.11"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-node", Labels: web},
+			Spec:       corev1.PodSpec{HostNetwork: true},
+		},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-5d4", OwnerReferences: ownedBy("Deployment", "web")}},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "postgres"},
+			Spec:       appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "postgres"}}}},
+		},
+	)
+
+	inventory, err := LoadInventory(clientset.CoreV1(), clientset.AppsV1())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "allow-web"},
+		Spec: netv1.NetworkPolicySpec{
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+			Ingress: []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{
+				PodSelector:       &metav1.LabelSelector{MatchLabels: web},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			}}}},
+		},
+	}
+	translated := TranslatePolicies([]netv1.NetworkPolicy{policy})
+	ResolveWorkloads(translated, inventory)
+
+	allow := translated[0].Rules[0]
+	wantFrom := []Workload{{Namespace: "shop", Pod: "web-1", IPs: []string{"10.128.0.10"}, OwnerKind: "Deployment", OwnerName: "web"}}
+	if !reflect.DeepEqual(allow.From.Workloads, wantFrom) {
+		t.Errorf("got sources %+v, want %+v", allow.From.Workloads, wantFrom)
+	}
+	wantTo := []Workload{{Namespace: "db", Pod: "postgres", OwnerKind: "StatefulSet", OwnerName: "postgres", Template: true}}
+	if !reflect.DeepEqual(allow.To.Workloads, wantTo) {
+		t.Errorf("got destinations %+v, want %+v", allow.To.Workloads, wantTo)
+	}
+}
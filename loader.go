@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// defaultManifestNamespace is used for manifests that do not set a namespace, like kubectl does
+const defaultManifestNamespace = "default"
+
+// manifestExtensions lists the file extensions read when walking a directory
+var manifestExtensions = map[string]bool{
+	".yaml": true,
+	".yml":  true,
+	".json": true,
+}
+
+// networkPolicyGroups lists the API groups that served the NetworkPolicy kind
+var networkPolicyGroups = map[string]bool{
+	netv1.GroupName: true,
+	"extensions":    true,
+}
+
+// Manifests holds the objects read from manifest files
+type Manifests struct {
+	Policies        []netv1.NetworkPolicy
+	EgressFirewalls []EgressFirewall
+	AdminPolicies   []AdminNetworkPolicy
+	MultiPolicies   []MultiNetworkPolicy
+	Inventory       Inventory
+}
+
+// LoadManifests reads NetworkPolicy manifests, and the namespaces, pods and workloads they select,
+// from files, directories (recursively) and stdin ("-")
+func LoadManifests(paths []string) (*Manifests, error) {
+	manifests := new(Manifests)
+
+	for _, path := range paths {
+		if err := manifests.loadPath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifests, nil
+}
+
+// loadPath reads the objects found in a single file, directory or stdin
+func (m *Manifests) loadPath(path string) error {
+	if path == "-" {
+		return m.decodeManifests(os.Stdin, "stdin")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return m.loadFile(path)
+	}
+
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !manifestExtensions[strings.ToLower(filepath.Ext(file))] {
+			return nil
+		}
+		return m.loadFile(file)
+	})
+}
+
+// loadFile reads the objects found in a single manifest file
+func (m *Manifests) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return m.decodeManifests(file, path)
+}
+
+// decodeManifests decodes a stream of YAML or JSON documents
+func (m *Manifests) decodeManifests(r io.Reader, source string) error {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+
+		if err := m.decodeObject(raw, source); err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+	}
+}
+
+// decodeObject keeps a single object, unwrapping lists
+// objects of any other kind are ignored, and so are documents that are not objects with a warning
+func (m *Manifests) decodeObject(raw json.RawMessage, source string) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) == 0 || trimmed[0] != '{' {
+		println(source+":", "skipping a document that is not an object")
+		return nil
+	}
+
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return err
+	}
+	gv, err := schema.ParseGroupVersion(typeMeta.APIVersion)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case typeMeta.Kind == "NetworkPolicy" && networkPolicyGroups[gv.Group]:
+		var policy netv1.NetworkPolicy
+		if err := json.Unmarshal(raw, &policy); err != nil {
+			return err
+		}
+		defaultPolicy(&policy)
+		m.Policies = append(m.Policies, policy)
+
+	case egressFirewallKinds[typeMeta.Kind] && gv.Group == egressFirewallGroups[typeMeta.Kind].Group:
+		var firewall EgressFirewall
+		if err := json.Unmarshal(raw, &firewall); err != nil {
+			return err
+		}
+		if firewall.Namespace == "" {
+			firewall.Namespace = defaultManifestNamespace
+		}
+		m.EgressFirewalls = append(m.EgressFirewalls, firewall)
+
+	case adminNetworkPolicyTiers[typeMeta.Kind] != "" && gv.Group == adminNetworkPolicyGroup.Group:
+		var policy AdminNetworkPolicy
+		if err := json.Unmarshal(raw, &policy); err != nil {
+			return err
+		}
+		m.AdminPolicies = append(m.AdminPolicies, policy)
+
+	case typeMeta.Kind == KindMultiNetworkPolicy && gv.Group == multiNetworkPolicyGroup.Group:
+		var policy MultiNetworkPolicy
+		if err := json.Unmarshal(raw, &policy); err != nil {
+			return err
+		}
+		if policy.Namespace == "" {
+			policy.Namespace = defaultManifestNamespace
+		}
+		m.MultiPolicies = append(m.MultiPolicies, policy)
+
+	case typeMeta.Kind == "Namespace" && gv.Group == corev1.GroupName:
+		var namespace corev1.Namespace
+		if err := json.Unmarshal(raw, &namespace); err != nil {
+			return err
+		}
+		m.Inventory.Namespaces = append(m.Inventory.Namespaces, namespace)
+
+	case typeMeta.Kind == "Pod" && gv.Group == corev1.GroupName:
+		var pod corev1.Pod
+		if err := json.Unmarshal(raw, &pod); err != nil {
+			return err
+		}
+		if pod.Namespace == "" {
+			pod.Namespace = defaultManifestNamespace
+		}
+		m.Inventory.Pods = append(m.Inventory.Pods, pod)
+
+	case typeMeta.Kind == "ReplicaSet" && gv.Group == appsv1.GroupName:
+		var replicaSet appsv1.ReplicaSet
+		if err := json.Unmarshal(raw, &replicaSet); err != nil {
+			return err
+		}
+		if replicaSet.Namespace == "" {
+			replicaSet.Namespace = defaultManifestNamespace
+		}
+		m.Inventory.ReplicaSets = append(m.Inventory.ReplicaSets, replicaSet)
+
+	case typeMeta.Kind == "Deployment" && gv.Group == appsv1.GroupName:
+		var deployment appsv1.Deployment
+		if err := json.Unmarshal(raw, &deployment); err != nil {
+			return err
+		}
+		if deployment.Namespace == "" {
+			deployment.Namespace = defaultManifestNamespace
+		}
+		m.Inventory.Deployments = append(m.Inventory.Deployments, deployment)
+
+	case typeMeta.Kind == "StatefulSet" && gv.Group == appsv1.GroupName:
+		var statefulSet appsv1.StatefulSet
+		if err := json.Unmarshal(raw, &statefulSet); err != nil {
+			return err
+		}
+		if statefulSet.Namespace == "" {
+			statefulSet.Namespace = defaultManifestNamespace
+		}
+		m.Inventory.StatefulSets = append(m.Inventory.StatefulSets, statefulSet)
+
+	case typeMeta.Kind == "DaemonSet" && gv.Group == appsv1.GroupName:
+		var daemonSet appsv1.DaemonSet
+		if err := json.Unmarshal(raw, &daemonSet); err != nil {
+			return err
+		}
+		if daemonSet.Namespace == "" {
+			daemonSet.Namespace = defaultManifestNamespace
+		}
+		m.Inventory.DaemonSets = append(m.Inventory.DaemonSets, daemonSet)
+
+	case strings.HasSuffix(typeMeta.Kind, "List"):
+		var list struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return err
+		}
+		for _, item := range list.Items {
+			// items of a typed list usually omit their own kind
+			if typeMeta.Kind != "List" {
+				item = withKind(item, typeMeta.APIVersion, strings.TrimSuffix(typeMeta.Kind, "List"))
+			}
+			if err := m.decodeObject(item, source); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// withKind sets apiVersion and kind on a raw object when it does not carry them
+func withKind(raw json.RawMessage, apiVersion, kind string) json.RawMessage {
+	var object map[string]interface{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return raw
+	}
+	if _, ok := object["kind"]; ok {
+		return raw
+	}
+	object["apiVersion"] = apiVersion
+	object["kind"] = kind
+
+	out, err := json.Marshal(object)
+	if err != nil {
+		return raw
+	}
+	return out
+}
+
+// defaultPolicy applies the defaults the API server sets on a stored NetworkPolicy
+func defaultPolicy(policy *netv1.NetworkPolicy) {
+	if policy.Namespace == "" {
+		policy.Namespace = defaultManifestNamespace
+	}
+
+	if len(policy.Spec.PolicyTypes) == 0 {
+		policy.Spec.PolicyTypes = []netv1.PolicyType{netv1.PolicyTypeIngress}
+		if len(policy.Spec.Egress) != 0 {
+			policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, netv1.PolicyTypeEgress)
+		}
+	}
+
+	for i := range policy.Spec.Ingress {
+		defaultPorts(policy.Spec.Ingress[i].Ports)
+	}
+	for i := range policy.Spec.Egress {
+		defaultPorts(policy.Spec.Egress[i].Ports)
+	}
+}
+
+// defaultPorts sets the TCP protocol on ports that do not specify one
+func defaultPorts(ports []netv1.NetworkPolicyPort) {
+	for i := range ports {
+		if ports[i].Protocol == nil {
+			protocol := corev1.ProtocolTCP
+			ports[i].Protocol = &protocol
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	loaderPolicy = `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata: {name: web, namespace: shop}
+spec:
+  podSelector: {}
+`
+	loaderNamespace = `apiVersion: v1
+kind: Namespace
+metadata: {name: shop}
+`
+	loaderPodList = `apiVersion: v1
+kind: PodList
+items:
+- metadata: {name: web-0, namespace: shop}
+- metadata: {name: web-1}
+`
+)
+
+func TestLoadManifests(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		paths      []string
+		stdin      string
+		policies   int
+		namespaces int
+		pods       []string
+		wantErr    bool
+	}{
+		{
+			name:     "multi-document file",
+			files:    map[string]string{"all.yaml": loaderNamespace + "---\n" + loaderPolicy + "---\n" + loaderPodList},
+			paths:    []string{"all.yaml"},
+			policies: 1, namespaces: 1, pods: []string{"shop/web-0", "default/web-1"},
+		},
+		{
+			name: "directory walked recursively, other extensions left out",
+			files: map[string]string{
+				"policy.yaml":          loaderPolicy,
+				"inventory/ns.yml":     loaderNamespace,
+				"inventory/pods.json":  `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web-0", "namespace": "shop"}}`,
+				"inventory/README.txt": loaderPolicy,
+			},
+			paths:    []string{"."},
+			policies: 1, namespaces: 1, pods: []string{"shop/web-0"},
+		},
+		{
+			name: "generic list with items of every kind",
+			files: map[string]string{"list.yaml": `apiVersion: v1
+kind: List
+items:
+- ` + indent(loaderNamespace) + `
+- ` + indent(loaderPolicy)},
+			paths:    []string{"list.yaml"},
+			policies: 1, namespaces: 1,
+		},
+		{
+			name:     "stdin",
+			stdin:    loaderPolicy + "---\n" + loaderPodList,
+			paths:    []string{"-"},
+			policies: 1, pods: []string{"shop/web-0", "default/web-1"},
+		},
+		{
+			name:     "documents that are not objects are skipped",
+			files:    map[string]string{"mixed.yaml": "- a\n- b\n---\nplain\n---\n" + loaderPolicy, "array.json": `[{"kind": "Namespace"}]`},
+			paths:    []string{"mixed.yaml", "array.json"},
+			policies: 1,
+		},
+		{
+			name:    "missing file",
+			paths:   []string{"missing.yaml"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "manifests")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range test.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.stdin != "" {
+				stdin := filepath.Join(dir, "stdin")
+				if err := ioutil.WriteFile(stdin, []byte(test.stdin), 0644); err != nil {
+					t.Fatal(err)
+				}
+				file, err := os.Open(stdin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+				saved := os.Stdin
+				os.Stdin = file
+				defer func() { os.Stdin = saved }()
+			}
+
+			var paths []string
+			for _, path := range test.paths {
+				if path != "-" {
+					path = filepath.Join(dir, path)
+				}
+				paths = append(paths, path)
+			}
+			manifests, err := LoadManifests(paths)
+			if test.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(manifests.Policies) != test.policies {
+				t.Errorf("got %d policies, want %d", len(manifests.Policies), test.policies)
+			}
+			if len(manifests.Inventory.Namespaces) != test.namespaces {
+				t.Errorf("got %d namespaces, want %d", len(manifests.Inventory.Namespaces), test.namespaces)
+			}
+			var pods []string
+			for _, pod := range manifests.Inventory.Pods {
+				pods = append(pods, pod.Namespace+"/"+pod.Name)
+			}
+			if !reflect.DeepEqual(pods, test.pods) {
+				t.Errorf("got pods %v, want %v", pods, test.pods)
+			}
+		})
+	}
+}
+
+// indent indents the lines of a document after the first one to nest it as a list item
+func indent(document string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(document, "\n"), "\n", "\n  ") + "\n"
+}
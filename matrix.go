@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Levels of a connectivity matrix
+const (
+	MatrixNamespace = "namespace"
+	MatrixWorkload  = "workload"
+)
+
+// MatrixCell is the connectivity from a source namespace or workload to a destination one
+// Ports are the ports allowed between any of their pods, Partial is set when the pods of the pair are not all allowed the same
+type MatrixCell struct {
+	From    string   `json:"from" header:"From"`
+	To      string   `json:"to" header:"To"`
+	Allowed bool     `json:"allowed" header:"Allowed"`
+	Partial bool     `json:"partial,omitempty" header:"Partial"`
+	Ports   []string `json:"ports,omitempty" header:"Ports"`
+}
+
+// matrixGroup is a row or column of the matrix with the endpoints standing for it
+type matrixGroup struct {
+	name      string
+	endpoints []Endpoint
+}
+
+// ConnectivityMatrix evaluates the traffic allowed between every pair of namespaces or workloads
+// a connection needs both the egress side of the source and the ingress side of the destination to allow it
+func ConnectivityMatrix(policies []FirewallPolicy, inventory *Inventory, level string) []MatrixCell {
+	groups := matrixGroups(policies, inventory, level)
+
+	var cells []MatrixCell
+	for _, from := range groups {
+		for _, to := range groups {
+			allowed := NewPortSet()
+			partial := false
+			first := ""
+			for i, source := range from.endpoints {
+				for j, destination := range to.endpoints {
+					pair := EvaluateConnection(policies, source, destination).Allowed()
+					if i == 0 && j == 0 {
+						first = pair.String()
+					} else if pair.String() != first {
+						partial = true
+					}
+					allowed = allowed.Union(pair)
+				}
+			}
+			cell := MatrixCell{From: from.name, To: to.name, Allowed: !allowed.Empty(), Partial: partial}
+			if cell.Allowed {
+				cell.Ports = allowed.Keys()
+			}
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// matrixGroups returns the sorted groups of the matrix, one per workload or per namespace, with all their pods
+func matrixGroups(policies []FirewallPolicy, inventory *Inventory, level string) []*matrixGroup {
+	var endpoints []Endpoint
+	var workloads []string
+	if inventory != nil {
+		for _, pod := range inventory.NetworkPods() {
+			endpoints = append(endpoints, inventory.podEndpoint(pod))
+			workloads = append(workloads, workloadName(inventory, pod))
+		}
+	}
+	if len(endpoints) == 0 {
+		endpoints = syntheticEndpoints(policies)
+		for _, endpoint := range endpoints {
+			workloads = append(workloads, endpoint.Namespace+"/"+endpoint.Name)
+		}
+	}
+
+	index := map[string]*matrixGroup{}
+	var groups []*matrixGroup
+	for i, endpoint := range endpoints {
+		name := workloads[i]
+		if level == MatrixNamespace {
+			name = endpoint.Namespace
+		}
+		if index[name] == nil {
+			index[name] = &matrixGroup{name: name}
+			groups = append(groups, index[name])
+		}
+		index[name].endpoints = append(index[name].endpoints, endpoint)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
+
+// workloadName returns namespace/kind/name of the workload owning a pod, namespace/pod for pods without owner
+func workloadName(inventory *Inventory, pod corev1.Pod) string {
+	return inventory.Workload(pod).Name()
+}
+
+// podEndpoint returns the endpoint of a pod
+func (inv *Inventory) podEndpoint(pod corev1.Pod) Endpoint {
+	return Endpoint{
+		Namespace:       pod.Namespace,
+		Name:            pod.Name,
+		NamespaceLabels: inv.NamespaceLabels(pod.Namespace),
+		Labels:          labels.Set(pod.Labels),
+		IPs:             inv.Workload(pod).IPs,
+		Resolved:        true,
+		Network:         inv.Network,
+	}
+}
+
+// syntheticEndpoints returns endpoints standing for the pods of the policies when no pod is known,
+// one per pod selector match labels of every namespace and an unlabeled one per namespace
+func syntheticEndpoints(policies []FirewallPolicy) []Endpoint {
+	var endpoints []Endpoint
+	seen := map[string]bool{}
+	add := func(namespace string, podLabels map[string]string, network string) {
+		name := labels.Set(podLabels).String()
+		if name == "" {
+			name = "*"
+		}
+		if namespace == "" || seen[network+"\x00"+namespace+"/"+name] {
+			return
+		}
+		seen[network+"\x00"+namespace+"/"+name] = true
+		endpoints = append(endpoints, Endpoint{
+			Namespace:       namespace,
+			Name:            name,
+			NamespaceLabels: labels.Set{namespaceNameLabel: namespace},
+			Labels:          labels.Set(podLabels),
+			Network:         network,
+		})
+	}
+
+	for _, policy := range policies {
+		add(policy.Namespace, nil, policy.Network)
+		for _, rule := range policy.Rules {
+			for _, location := range []FirewallLocation{rule.From, rule.To} {
+				if location.PodSelector != nil && len(location.PodSelector.MatchExpressions) == 0 {
+					add(location.Namespace, location.PodSelector.MatchLabels, location.Network)
+				}
+			}
+		}
+	}
+	return endpoints
+}
+
+// PrintMatrix writes a connectivity matrix as a table, JSON or CSV
+func PrintMatrix(w io.Writer, cells []MatrixCell, format string) error {
+	switch format {
+	case "table":
+		newTablePrinter(w).Print(cells)
+		return nil
+	case "json":
+		return json.NewEncoder(w).Encode(cells)
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"from", "to", "allowed", "partial", "ports"}); err != nil {
+			return err
+		}
+		for _, cell := range cells {
+			if err := writer.Write([]string{cell.From, cell.To, strconv.FormatBool(cell.Allowed), strconv.FormatBool(cell.Partial), strings.Join(cell.Ports, " ")}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unknown matrix format %q, use table, json or csv", format)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConnectivityMatrixPartial(t *testing.T) {
+	tests := []struct {
+		level string
+		from  string
+		to    string
+		want  MatrixCell
+	}{
+		{
+			// the pods of the statefulset define the named port differently
+			level: MatrixWorkload,
+			from:  "edge/client-0",
+			to:    "shop/StatefulSet/web",
+			want:  MatrixCell{From: "edge/client-0", To: "shop/StatefulSet/web", Allowed: true, Partial: true, Ports: []string{"TCP/8080", "TCP/9090"}},
+		},
+		{
+			level: MatrixWorkload,
+			from:  "edge/client-0",
+			to:    "shop/cache-0",
+			want:  MatrixCell{From: "edge/client-0", To: "shop/cache-0"},
+		},
+		{
+			// the cache pod is isolated while the web pods allow their named port
+			level: MatrixNamespace,
+			from:  "edge",
+			to:    "shop",
+			want:  MatrixCell{From: "edge", To: "shop", Allowed: true, Partial: true, Ports: []string{"TCP/8080", "TCP/9090"}},
+		},
+		{
+			level: MatrixNamespace,
+			from:  "shop",
+			to:    "edge",
+			want:  MatrixCell{From: "shop", To: "edge", Allowed: true, Ports: []string{"ANY"}},
+		},
+	}
+
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", "partial.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := TranslatePolicies(manifests.Policies)
+	ResolveWorkloads(policies, &manifests.Inventory)
+	ResolveNamedPorts(policies, &manifests.Inventory)
+
+	for _, test := range tests {
+		t.Run(test.level+" "+test.from+" to "+test.to, func(t *testing.T) {
+			var got *MatrixCell
+			for _, cell := range ConnectivityMatrix(policies, &manifests.Inventory, test.level) {
+				if cell.From == test.from && cell.To == test.to {
+					cell := cell
+					got = &cell
+				}
+			}
+			if got == nil {
+				t.Fatalf("no cell from %s to %s", test.from, test.to)
+			}
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("got %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// KindMultiNetworkPolicy is the kind of the policies of the secondary networks attached by Multus
+const KindMultiNetworkPolicy = "MultiNetworkPolicy"
+
+// multiNetworkPolicyGroup is the API group of the MultiNetworkPolicies
+var multiNetworkPolicyGroup = schema.GroupVersion{Group: "k8s.cni.cncf.io", Version: "v1beta1"}
+
+// Annotations naming the networks of MultiNetworkPolicies and pods
+const (
+	// policyForAnnotation lists the NetworkAttachmentDefinitions a MultiNetworkPolicy applies to
+	policyForAnnotation = "k8s.v1.cni.cncf.io/policy-for"
+	// networksAnnotation lists the networks a pod asks to be attached to
+	networksAnnotation = "k8s.v1.cni.cncf.io/networks"
+	// networkStatusAnnotation lists the networks a pod is attached to with their addresses
+	networkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+)
+
+// MultiNetworkPolicy is a NetworkPolicy for the secondary networks named by its policy-for annotation
+type MultiNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              netv1.NetworkPolicySpec `json:"spec"`
+}
+
+// LoadMultiNetworkPolicies lists the MultiNetworkPolicies through the controller-runtime client,
+// none when the cluster does not serve them
+func LoadMultiNetworkPolicies(c client.Client) ([]MultiNetworkPolicy, error) {
+	list := new(unstructured.UnstructuredList)
+	list.SetGroupVersionKind(multiNetworkPolicyGroup.WithKind(KindMultiNetworkPolicy + "List"))
+	if err := c.List(context.Background(), list); err != nil {
+		if meta.IsNoMatchError(err) || apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var multiPolicies []MultiNetworkPolicy
+	for _, item := range list.Items {
+		var policy MultiNetworkPolicy
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &policy); err != nil {
+			return nil, fmt.Errorf("%s %s/%s: %v", KindMultiNetworkPolicy, item.GetNamespace(), item.GetName(), err)
+		}
+		multiPolicies = append(multiPolicies, policy)
+	}
+	return multiPolicies, nil
+}
+
+// MultiNetworkPolicyTranslator translates a MultiNetworkPolicy like a NetworkPolicy, once per network
+// it applies to, every location of the rules is on the network of its FirewallPolicy
+func (t *Translator) MultiNetworkPolicyTranslator(policy MultiNetworkPolicy) {
+	networks := policyNetworks(policy)
+	if len(networks) == 0 {
+		println("skipped", KindMultiNetworkPolicy, policy.Namespace+"/"+policy.Name, ": no", policyForAnnotation, "annotation")
+		return
+	}
+
+	// the CRD does not default the policy types and ports like the API server does for network policies
+	networkPolicy := netv1.NetworkPolicy{ObjectMeta: policy.ObjectMeta, Spec: policy.Spec}
+	defaultPolicy(&networkPolicy)
+	for _, network := range networks {
+		t.PolicyRulesTranslator(networkPolicy)
+
+		translated := &t.Policies[len(t.Policies)-1]
+		translated.Kind = KindMultiNetworkPolicy
+		translated.Network = network
+		for i := range translated.Rules {
+			rule := &translated.Rules[i]
+			rule.Policy = translated.Reference()
+			rule.From.Network = network
+			rule.To.Network = network
+		}
+	}
+}
+
+// TranslateMultiNetworkPolicies translates MultiNetworkPolicies after the policies already translated
+func (t *Translator) TranslateMultiNetworkPolicies(multiPolicies []MultiNetworkPolicy) []FirewallPolicy {
+	for _, policy := range multiPolicies {
+		t.MultiNetworkPolicyTranslator(policy)
+	}
+	return t.Policies
+}
+
+// policyNetworks returns the namespace/name of the networks of a MultiNetworkPolicy,
+// names without namespace are in the namespace of the policy
+func policyNetworks(policy MultiNetworkPolicy) []string {
+	var networks []string
+	for _, name := range strings.Split(policy.Annotations[policyForAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			networks = append(networks, qualifiedNetwork(name, policy.Namespace))
+		}
+	}
+	return uniqueSorted(networks)
+}
+
+// qualifiedNetwork returns namespace/name of a network name, in the given namespace when it has none
+func qualifiedNetwork(name string, namespace string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// podNetworks returns the addresses of a pod on each secondary network it is attached to, by namespace/name
+// the network status gives them once the pod runs, before only the requested networks are known
+func podNetworks(pod corev1.Pod) map[string][]string {
+	networks := map[string][]string{}
+
+	var statuses []struct {
+		Name    string   `json:"name"`
+		IPs     []string `json:"ips"`
+		Default bool     `json:"default"`
+	}
+	if status := pod.Annotations[networkStatusAnnotation]; status != "" && json.Unmarshal([]byte(status), &statuses) == nil {
+		for _, status := range statuses {
+			if !status.Default {
+				network := qualifiedNetwork(status.Name, pod.Namespace)
+				networks[network] = append(networks[network], status.IPs...)
+			}
+		}
+		return networks
+	}
+
+	requested := pod.Annotations[networksAnnotation]
+	var selections []struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	}
+	if strings.HasPrefix(strings.TrimSpace(requested), "[") {
+		if json.Unmarshal([]byte(requested), &selections) == nil {
+			for _, selection := range selections {
+				namespace := selection.Namespace
+				if namespace == "" {
+					namespace = pod.Namespace
+				}
+				networks[namespace+"/"+selection.Name] = nil
+			}
+		}
+		return networks
+	}
+	for _, name := range strings.Split(requested, ",") {
+		// an interface name may follow the network name
+		if name = strings.TrimSpace(strings.SplitN(name, "@", 2)[0]); name != "" {
+			networks[qualifiedNetwork(name, pod.Namespace)] = nil
+		}
+	}
+	return networks
+}
+
+// FilterNetwork returns the policies of a secondary network
+func FilterNetwork(policies []FirewallPolicy, network string) []FirewallPolicy {
+	var filtered []FirewallPolicy
+	for _, policy := range policies {
+		if policy.Network == network {
+			filtered = append(filtered, policy)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestPolicyNetworks(t *testing.T) {
+	tests := []struct {
+		name      string
+		policyFor string
+		want      []string
+	}{
+		{name: "no annotation"},
+		{name: "network of the policy namespace", policyFor: "macvlan", want: []string{"ns/macvlan"}},
+		{name: "network of another namespace", policyFor: "infra/macvlan", want: []string{"infra/macvlan"}},
+		{name: "several networks, sorted and once", policyFor: " sriov, infra/macvlan,,sriov", want: []string{"infra/macvlan", "ns/sriov"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := MultiNetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db"},
+				Spec:       netv1.NetworkPolicySpec{Ingress: []netv1.NetworkPolicyIngressRule{{}}},
+			}
+			if test.policyFor != "" {
+				policy.Annotations = map[string]string{policyForAnnotation: test.policyFor}
+			}
+
+			// the policy is translated once per network, every location on that network
+			var got []string
+			for _, translated := range NewTranslator(0).TranslateMultiNetworkPolicies([]MultiNetworkPolicy{policy}) {
+				got = append(got, translated.Network)
+				if translated.Kind != KindMultiNetworkPolicy {
+					t.Errorf("got kind %q, want %s", translated.Kind, KindMultiNetworkPolicy)
+				}
+				for _, rule := range translated.Rules {
+					if rule.From.Network != translated.Network || rule.To.Network != translated.Network {
+						t.Errorf("rule %s is on networks %q and %q, want %q", ruleLine(rule), rule.From.Network, rule.To.Network, translated.Network)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got networks %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPodNetworks(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        map[string][]string
+	}{
+		{name: "no annotation", want: map[string][]string{}},
+		{
+			name: "network status without the default network",
+			annotations: map[string]string{networkStatusAnnotation: `[
+				{"name": "ovn-kubernetes", "ips": ["10.128.0.5"], "default": true},
+				{"name": "ns/macvlan", "ips": ["192.168.1.10"]},
+				{"name": "sriov", "ips": ["192.168.2.10", "fd00::10"]}]`},
+			want: map[string][]string{"ns/macvlan": {"192.168.1.10"}, "ns/sriov": {"192.168.2.10", "fd00::10"}},
+		},
+		{
+			name: "network status preferred to the requested networks",
+			annotations: map[string]string{
+				networksAnnotation:      "macvlan,sriov",
+				networkStatusAnnotation: `[{"name": "ns/macvlan", "ips": ["192.168.1.10"]}]`,
+			},
+			want: map[string][]string{"ns/macvlan": {"192.168.1.10"}},
+		},
+		{
+			name:        "requested networks by name with interfaces",
+			annotations: map[string]string{networksAnnotation: "macvlan@eth1, infra/sriov"},
+			want:        map[string][]string{"ns/macvlan": nil, "infra/sriov": nil},
+		},
+		{
+			name:        "requested networks as selections",
+			annotations: map[string]string{networksAnnotation: `[{"name": "macvlan"}, {"name": "sriov", "namespace": "infra", "interface": "net1"}]`},
+			want:        map[string][]string{"ns/macvlan": nil, "infra/sriov": nil},
+		},
+		{
+			name:        "invalid network status falls back to the requested networks",
+			annotations: map[string]string{networksAnnotation: "macvlan", networkStatusAnnotation: "{"},
+			want:        map[string][]string{"ns/macvlan": nil},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db-0", Annotations: test.annotations}}
+			if got := podNetworks(pod); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got networks %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMultiNetworkQuery(t *testing.T) {
+	pod := func(name string, app string, status string) corev1.Pod {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, Labels: map[string]string{"app": app}},
+			Status:     corev1.PodStatus{PodIP: "10.128.0." + name[len(name)-1:]},
+		}
+		if status != "" {
+			pod.Annotations = map[string]string{networkStatusAnnotation: status}
+		}
+		return pod
+	}
+	inventory := &Inventory{Pods: []corev1.Pod{
+		pod("db-1", "db", `[{"name": "ns/macvlan", "ips": ["192.168.1.1"]}]`),
+		pod("api-2", "api", `[{"name": "macvlan", "ips": ["192.168.1.2"]}]`),
+		pod("web-3", "web", `[{"name": "ns/sriov", "ips": ["192.168.2.3"]}]`),
+		pod("job-4", "api", ""),
+	}}
+
+	postgres := intstr.FromInt(5432)
+	policy := MultiNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db", Annotations: map[string]string{policyForAnnotation: "macvlan"}},
+		Spec: netv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Ingress: []netv1.NetworkPolicyIngressRule{{
+				From:  []netv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}}},
+				Ports: []netv1.NetworkPolicyPort{{Port: &postgres}},
+			}},
+		},
+	}
+	translated := NewTranslator(0).TranslateMultiNetworkPolicies([]MultiNetworkPolicy{policy})
+
+	tests := []struct {
+		name    string
+		network string
+		from    string
+		to      string
+		port    string
+		allowed bool
+		wantErr bool
+	}{
+		{name: "allowed port on the network", network: "ns/macvlan", from: "ns/api-2", to: "ns/db-1", port: "TCP/5432", allowed: true},
+		{name: "other port on the network", network: "ns/macvlan", from: "ns/api-2", to: "ns/db-1", port: "TCP/80"},
+		{name: "address on the network", network: "ns/macvlan", from: "192.168.1.2", to: "192.168.1.1", port: "TCP/5432", allowed: true},
+		{name: "cluster network address is not on the network", network: "ns/macvlan", from: "10.128.0.2", to: "ns/db-1", port: "TCP/5432"},
+		{name: "pod of another network", network: "ns/macvlan", from: "ns/web-3", to: "ns/db-1", port: "TCP/5432", wantErr: true},
+		{name: "pod not attached", network: "ns/macvlan", from: "ns/job-4", to: "ns/db-1", port: "TCP/5432", wantErr: true},
+		{name: "cluster network left open", from: "ns/job-4", to: "ns/db-1", port: "TCP/80", allowed: true},
+		{name: "attached pod left open on the cluster network", from: "ns/web-3", to: "ns/db-1", port: "TCP/80", allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the rules of every network are kept, their locations only match the endpoints of their network
+			view := inventory.OnNetwork(test.network)
+			policies := append([]FirewallPolicy{}, translated...)
+			ResolveWorkloads(policies, view)
+
+			source, err := ParseEndpoint(test.from, view)
+			if err != nil {
+				if !test.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.wantErr {
+				t.Fatalf("%s found on network %q", test.from, test.network)
+			}
+			destination, err := ParseEndpoint(test.to, view)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result := Query(policies, source, destination, test.port); result.Allowed != test.allowed {
+				t.Errorf("got allowed %v, want %v", result.Allowed, test.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Chains of the generated netfilter rulesets, egress firewall rules run first, then egress rules and ingress rules
+// traffic allowed by egress firewall and egress rules returns to be checked by the next chain
+const (
+	iptablesChain               = "NSM-FIREWALL"
+	iptablesEgressFirewallChain = "NSM-EGRESS-FIREWALL"
+	iptablesEgressChain         = "NSM-EGRESS"
+	iptablesIngressChain        = "NSM-INGRESS"
+	nftablesTable               = "nsm"
+)
+
+// Address families of the generated rules
+const (
+	familyIPv4 = "ip"
+	familyIPv6 = "ip6"
+)
+
+// netfilterRule is a rule with its endpoints resolved to addresses, Position is its place in the merged rulebase
+// Any endpoints match all addresses, Skip explains why a rule cannot be rendered
+type netfilterRule struct {
+	Rule     FirewallRule
+	Position int
+	From     []string
+	FromAny  bool
+	To       []string
+	ToAny    bool
+	Skip     string
+}
+
+// netfilterRules returns the rules of all policies in merged rulebase order with their addresses
+// selectors are rendered with the IPs of their resolved workloads
+func netfilterRules(policies []FirewallPolicy) []netfilterRule {
+	var rules []netfilterRule
+	merged := mergedRules(policies)
+	owners := exceptOwners(merged)
+	for i, rule := range merged {
+		r := newNetfilterRule(rule, owners[i])
+		r.Position = i
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// newNetfilterRule resolves the addresses of a rule and checks it can be rendered
+// a reject rule with an owner is the except block of that allow rule, which leaves the block out of its addresses
+// so the traffic goes on to the next rules, rejecting it first would override the other policies allowing it
+func newNetfilterRule(rule FirewallRule, owner *FirewallRule) netfilterRule {
+	r := netfilterRule{Rule: rule}
+	r.From, r.FromAny = locationAddresses(rule.From)
+	r.To, r.ToAny = locationAddresses(rule.To)
+	switch {
+	case owner != nil:
+		r.Skip = fmt.Sprintf("except block of %s, left out of its addresses", ruleComment(*owner))
+	case rule.Tier != "":
+		r.Skip = rule.Tier + " tier rules apply around the network policies and are not exported"
+	case !r.FromAny && len(r.From) == 0:
+		r.Skip = "no address for source " + FormatLocation(rule.From)
+	case !r.ToAny && len(r.To) == 0:
+		r.Skip = "no address for destination " + FormatLocation(rule.To)
+	case !hasNumericPorts(*rule.PortsLocation()):
+		r.Skip = "unresolved named ports " + FormatPorts(*rule.PortsLocation())
+	}
+	return r
+}
+
+// exceptOwners returns the allow rules owning the reject rules of their except blocks, by position of the reject,
+// an owner is an allow rule of the same policy, direction, target and ports whose CIDR has the rejected CIDR as except
+func exceptOwners(rules []FirewallRule) map[int]*FirewallRule {
+	owners := map[int]*FirewallRule{}
+	for i, reject := range rules {
+		if reject.Action != ActionReject {
+			continue
+		}
+		peer := peerLocation(reject)
+		for j, allow := range rules {
+			allowPeer := peerLocation(allow)
+			if allow.Action == ActionAllow && allow.Policy == reject.Policy && allow.Direction == reject.Direction &&
+				FormatLocation(targetLocation(allow)) == FormatLocation(targetLocation(reject)) &&
+				FormatPorts(allowPeer) == FormatPorts(peer) && hasExcept(allowPeer, peer.CIDR) {
+				owners[i] = &rules[j]
+				break
+			}
+		}
+	}
+	return owners
+}
+
+// hasExcept reports if a CIDR is an except block of a location
+func hasExcept(location FirewallLocation, cidr string) bool {
+	for _, except := range location.Except {
+		if except == cidr {
+			return true
+		}
+	}
+	return false
+}
+
+// locationAddresses returns the addresses of a location, or true when it matches any address
+// a CIDR with except blocks is split into the prefixes left around them
+func locationAddresses(location FirewallLocation) ([]string, bool) {
+	if location.Any {
+		return nil, true
+	}
+	if location.CIDR != "" {
+		return excludeCIDRs(location.CIDR, location.Except), false
+	}
+
+	var addresses []string
+	seen := map[string]bool{}
+	for _, workload := range location.Workloads {
+		for _, ip := range workload.IPs {
+			if !seen[ip] {
+				seen[ip] = true
+				addresses = append(addresses, ip)
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, false
+}
+
+// excludeCIDRs returns the prefixes covering a CIDR but its except blocks, the CIDR itself without except block
+func excludeCIDRs(cidr string, excepts []string) []string {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil || len(excepts) == 0 {
+		return []string{cidr}
+	}
+
+	remaining := []*net.IPNet{network}
+	for _, except := range excepts {
+		_, block, err := net.ParseCIDR(except)
+		if err != nil {
+			continue
+		}
+		var next []*net.IPNet
+		for _, prefix := range remaining {
+			next = append(next, splitOut(prefix, block)...)
+		}
+		remaining = next
+	}
+
+	var prefixes []string
+	for _, prefix := range remaining {
+		prefixes = append(prefixes, prefix.String())
+	}
+	return prefixes
+}
+
+// splitOut returns the prefixes of a network left once a block is taken out, halving the network down to the block
+func splitOut(network *net.IPNet, block *net.IPNet) []*net.IPNet {
+	switch {
+	case covers(block, network):
+		return nil
+	case !covers(network, block):
+		return []*net.IPNet{network}
+	}
+
+	ones, bits := network.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+	low := &net.IPNet{IP: network.IP.Mask(mask), Mask: mask}
+	high := &net.IPNet{IP: append(net.IP{}, low.IP...), Mask: mask}
+	high.IP[ones/8] |= 0x80 >> uint(ones%8)
+	return append(splitOut(low, block), splitOut(high, block)...)
+}
+
+// namedPorts returns the named ports of a location left out of the generated rules, the ones still named and
+// the ones no target pod declares, netfilter only matches port numbers
+func namedPorts(location FirewallLocation) []string {
+	names := append([]string{}, location.UnresolvedPorts...)
+	for _, port := range location.Ports {
+		if port.Port != nil && port.Port.Type == intstr.String {
+			names = append(names, portKey(port))
+		}
+	}
+	return uniqueSorted(names)
+}
+
+// hasNumericPorts reports if a location has ports netfilter can match, named ports are left out
+func hasNumericPorts(location FirewallLocation) bool {
+	if location.AllPorts || len(location.Ports) == 0 {
+		return true
+	}
+	for _, port := range location.Ports {
+		if port.Port == nil || port.Port.Type == intstr.Int {
+			return true
+		}
+	}
+	return false
+}
+
+// familyAddresses returns the addresses of a family
+func familyAddresses(addresses []string, family string) []string {
+	var selected []string
+	for _, address := range addresses {
+		ip := net.ParseIP(strings.SplitN(address, "/", 2)[0])
+		if ip == nil {
+			continue
+		}
+		if (ip.To4() != nil) == (family == familyIPv4) {
+			selected = append(selected, address)
+		}
+	}
+	return selected
+}
+
+// ruleComment returns the comment pointing a generated rule to its policy, with the order of the rule in the policies
+func ruleComment(rule FirewallRule) string {
+	return fmt.Sprintf("%s rule %d %s", rule.Policy, rule.Order, rule.Action)
+}
+
+// noClusterCIDRs explains why egress firewall rules are left out of the netfilter outputs
+const noClusterCIDRs = "no cluster CIDRs, egress firewall rules only hold for traffic leaving the cluster"
+
+// warnEgressFirewall warns when the egress firewall rules of policies are left out for want of cluster CIDRs
+func warnEgressFirewall(policies []FirewallPolicy) {
+	for _, policy := range policies {
+		if egressFirewallKinds[policy.Kind] && len(policy.Rules) > 0 {
+			println("egress firewall rules left out, give the pod and service CIDRs of the cluster with -cluster-cidr")
+			return
+		}
+	}
+}
+
+// iptablesExporter writes the rules in iptables-save format for IPv4, the egress firewall chain returns the traffic
+// to the cluster CIDRs and is left out without IPv4 cluster CIDRs
+type iptablesExporter struct {
+	clusterCIDRs []string
+}
+
+func (e iptablesExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	clusterCIDRs := familyAddresses(e.clusterCIDRs, familyIPv4)
+	chains := []string{iptablesChain, iptablesEgressChain, iptablesIngressChain}
+	if len(clusterCIDRs) > 0 {
+		chains = []string{iptablesChain, iptablesEgressFirewallChain, iptablesEgressChain, iptablesIngressChain}
+	} else {
+		warnEgressFirewall(policies)
+	}
+
+	fmt.Fprintln(w, "# Generated by NetworkPolicyExporter, jump to "+iptablesChain+" from the FORWARD chain")
+	fmt.Fprintln(w, "*filter")
+	for _, chain := range chains {
+		fmt.Fprintf(w, ":%s - [0:0]\n", chain)
+	}
+	for _, chain := range chains[1:] {
+		fmt.Fprintf(w, "-A %s -j %s\n", iptablesChain, chain)
+	}
+	for _, cidr := range clusterCIDRs {
+		fmt.Fprintf(w, "-A %s -d %s -m comment --comment \"cluster destination\" -j RETURN\n", iptablesEgressFirewallChain, cidr)
+	}
+
+	for _, r := range netfilterRules(policies) {
+		if r.Skip == "" && egressFirewallKinds[r.Rule.Policy.Kind] && len(clusterCIDRs) == 0 {
+			r.Skip = noClusterCIDRs
+		}
+		if r.Skip != "" {
+			fmt.Fprintf(w, "# skipped %s: %s\n", ruleComment(r.Rule), r.Skip)
+			continue
+		}
+		from, to := familyAddresses(r.From, familyIPv4), familyAddresses(r.To, familyIPv4)
+		if (!r.FromAny && len(from) == 0) || (!r.ToAny && len(to) == 0) {
+			fmt.Fprintf(w, "# skipped %s: no IPv4 address\n", ruleComment(r.Rule))
+			continue
+		}
+		for _, port := range namedPorts(*r.Rule.PortsLocation()) {
+			fmt.Fprintf(w, "# skipped named port %s of %s\n", port, ruleComment(r.Rule))
+		}
+
+		var match []string
+		if !r.FromAny {
+			match = append(match, "-s "+strings.Join(from, ","))
+		}
+		if !r.ToAny {
+			match = append(match, "-d "+strings.Join(to, ","))
+		}
+
+		chain, target := iptablesEgressChain, "RETURN"
+		switch {
+		case egressFirewallKinds[r.Rule.Policy.Kind]:
+			chain = iptablesEgressFirewallChain
+		case r.Rule.Direction == netv1.PolicyTypeIngress:
+			chain, target = iptablesIngressChain, "ACCEPT"
+		}
+		switch r.Rule.Action {
+		case ActionReject:
+			target = "REJECT"
+		case ActionDeny:
+			target = "DROP"
+		}
+
+		for _, ports := range iptablesPorts(*r.Rule.PortsLocation()) {
+			fmt.Fprintf(w, "-A %s %s -m comment --comment \"%s\" -j %s\n", chain, strings.Join(append(match, ports...), " "), ruleComment(r.Rule), target)
+		}
+	}
+
+	_, err := fmt.Fprintln(w, "COMMIT")
+	return err
+}
+
+// iptablesPorts returns the port matches of a location, one per generated rule, named ports are left out and commented
+func iptablesPorts(location FirewallLocation) [][]string {
+	if location.AllPorts || len(location.Ports) == 0 {
+		return [][]string{nil}
+	}
+	var matches [][]string
+	for _, port := range location.Ports {
+		protocol := strings.ToLower(string(portProtocol(port)))
+		if port.Port != nil && port.Port.Type == intstr.String {
+			continue
+		}
+		if port.Port == nil {
+			matches = append(matches, []string{"-p " + protocol})
+			continue
+		}
+		matches = append(matches, []string{"-p " + protocol, "-m " + protocol, "--dport " + port.Port.String()})
+	}
+	return matches
+}
+
+// nftablesExporter writes the rules as an nft ruleset with a set for the addresses of every rule endpoint,
+// the egress firewall chain is jumped to for the destinations outside the cluster CIDRs of each family
+// and left out without cluster CIDRs
+type nftablesExporter struct {
+	clusterCIDRs []string
+}
+
+func (e nftablesExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	var sets []string
+	chains := map[string][]string{}
+	egressFirewall := len(e.clusterCIDRs) > 0
+	if !egressFirewall {
+		warnEgressFirewall(policies)
+	}
+
+	for _, r := range netfilterRules(policies) {
+		chain, verdict := "egress", "return"
+		switch {
+		case egressFirewallKinds[r.Rule.Policy.Kind]:
+			chain = "egress_firewall"
+			if r.Skip == "" && !egressFirewall {
+				r.Skip = noClusterCIDRs
+			}
+		case r.Rule.Direction == netv1.PolicyTypeIngress:
+			chain, verdict = "ingress", "accept"
+		}
+		if r.Skip != "" {
+			chains[chain] = append(chains[chain], fmt.Sprintf("# skipped %s: %s", ruleComment(r.Rule), r.Skip))
+			continue
+		}
+		for _, port := range namedPorts(*r.Rule.PortsLocation()) {
+			chains[chain] = append(chains[chain], fmt.Sprintf("# skipped named port %s of %s", port, ruleComment(r.Rule)))
+		}
+
+		switch r.Rule.Action {
+		case ActionReject:
+			verdict = "reject"
+		case ActionDeny:
+			verdict = "drop"
+		}
+
+		families := []string{familyIPv4, familyIPv6}
+		if r.FromAny && r.ToAny {
+			families = []string{""}
+		}
+		for _, family := range families {
+			var match, familySets []string
+			skip := false
+			for _, side := range []struct {
+				name      string
+				direction string
+				addresses []string
+				any       bool
+			}{{"from", "saddr", r.From, r.FromAny}, {"to", "daddr", r.To, r.ToAny}} {
+				if side.any {
+					continue
+				}
+				addresses := familyAddresses(side.addresses, family)
+				if len(addresses) == 0 {
+					skip = true
+					break
+				}
+				set := fmt.Sprintf("r%d_%s_%s", r.Position, side.name, family)
+				familySets = append(familySets, nftablesSet(set, family, addresses))
+				match = append(match, fmt.Sprintf("%s %s @%s", family, side.direction, set))
+			}
+			if skip {
+				continue
+			}
+			sets = append(sets, familySets...)
+			for _, ports := range nftablesPorts(*r.Rule.PortsLocation()) {
+				statement := strings.Join(append(append(match, ports...), verdict), " ")
+				chains[chain] = append(chains[chain], fmt.Sprintf("%s comment \"%s\"", statement, ruleComment(r.Rule)))
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "# Generated by NetworkPolicyExporter")
+	if !egressFirewall {
+		for _, statement := range chains["egress_firewall"] {
+			fmt.Fprintln(w, statement)
+		}
+	}
+	fmt.Fprintf(w, "table inet %s {\n", nftablesTable)
+	for _, set := range sets {
+		fmt.Fprint(w, set)
+	}
+	for _, chain := range []string{"egress_firewall", "egress", "ingress"} {
+		if chain == "egress_firewall" && !egressFirewall {
+			continue
+		}
+		fmt.Fprintf(w, "\tchain %s {\n", chain)
+		for _, statement := range chains[chain] {
+			fmt.Fprintf(w, "\t\t%s\n", statement)
+		}
+		fmt.Fprintln(w, "\t}")
+	}
+	fmt.Fprintln(w, "\tchain forward {")
+	fmt.Fprintln(w, "\t\ttype filter hook forward priority 0; policy accept;")
+	for _, family := range []string{familyIPv4, familyIPv6} {
+		if cidrs := familyAddresses(e.clusterCIDRs, family); len(cidrs) > 0 {
+			fmt.Fprintf(w, "\t\t%s daddr != { %s } jump egress_firewall\n", family, strings.Join(cidrs, ", "))
+		}
+	}
+	fmt.Fprintln(w, "\t\tjump egress")
+	fmt.Fprintln(w, "\t\tjump ingress")
+	fmt.Fprintln(w, "\t}")
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// nftablesSet declares a named set of addresses
+func nftablesSet(name string, family string, addresses []string) string {
+	kind := "ipv4_addr"
+	if family == familyIPv6 {
+		kind = "ipv6_addr"
+	}
+	return fmt.Sprintf("\tset %s {\n\t\ttype %s\n\t\tflags interval\n\t\tauto-merge\n\t\telements = { %s }\n\t}\n", name, kind, strings.Join(addresses, ", "))
+}
+
+// nftablesPorts returns the port matches of a location, one per protocol, named ports are left out and commented
+func nftablesPorts(location FirewallLocation) [][]string {
+	if location.AllPorts || len(location.Ports) == 0 {
+		return [][]string{nil}
+	}
+
+	numbers := map[corev1.Protocol][]string{}
+	var protocols []corev1.Protocol
+	for _, port := range location.Ports {
+		protocol := portProtocol(port)
+		if port.Port != nil && port.Port.Type == intstr.String {
+			continue
+		}
+		if _, ok := numbers[protocol]; !ok {
+			protocols = append(protocols, protocol)
+			numbers[protocol] = []string{}
+		}
+		// a port without number matches the whole protocol
+		if port.Port == nil {
+			numbers[protocol] = nil
+		} else if numbers[protocol] != nil {
+			numbers[protocol] = append(numbers[protocol], port.Port.String())
+		}
+	}
+
+	var matches [][]string
+	for _, protocol := range protocols {
+		name := strings.ToLower(string(protocol))
+		if numbers[protocol] == nil {
+			matches = append(matches, []string{"meta l4proto " + name})
+			continue
+		}
+		matches = append(matches, []string{fmt.Sprintf("%s dport { %s }", name, strings.Join(numbers[protocol], ", "))})
+	}
+	return matches
+}
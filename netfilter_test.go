@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the tests")
+
+// TestNetfilterGolden compares the iptables and nftables outputs of the fixtures with their golden files,
+// and the vendor firewall outputs the case lists
+func TestNetfilterGolden(t *testing.T) {
+	tests := []struct {
+		name         string
+		fixture      string
+		clusterCIDRs []string
+		formats      []string
+	}{
+		// web-0 is dual-stack, fortios matches its IPv6 address with srcaddr6 and dstaddr6
+		{name: "v", fixture: "v.yaml", formats: []string{"iptables", "nftables", "fortios"}},
+		// the except block of one policy must not reject the traffic another policy allows
+		{name: "except", fixture: "except.yaml", formats: []string{"iptables", "nftables", "panos"}},
+		// the egress firewall deny of every destination must not reach the pod to pod traffic the policy allows
+		{name: "egress-firewall", fixture: "egress-firewall.yaml", clusterCIDRs: []string{"10.128.0.0/14", "172.30.0.0/16", "fd01::/48"}},
+		{name: "egress-firewall-no-cidrs", fixture: "egress-firewall.yaml"},
+	}
+	for _, test := range tests {
+		formats := test.formats
+		if formats == nil {
+			formats = []string{"iptables", "nftables"}
+		}
+		for _, format := range formats {
+			t.Run(test.name+"/"+format, func(t *testing.T) {
+				manifests, err := LoadManifests([]string{filepath.Join("testdata", test.fixture)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				translator := NewTranslator(0)
+				translator.TranslatePolicies(manifests.Policies)
+				translated := translator.TranslateEgressFirewalls(manifests.EgressFirewalls)
+				ResolveWorkloads(translated, &manifests.Inventory)
+				ResolveNamedPorts(translated, &manifests.Inventory)
+				AssignZones(translated, nil, &manifests.Inventory)
+
+				exporter, err := NewExporter(format, ExportOptions{Inventory: &manifests.Inventory, ClusterCIDRs: test.clusterCIDRs})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var out bytes.Buffer
+				if err := exporter.Export(&out, translated); err != nil {
+					t.Fatal(err)
+				}
+
+				golden := filepath.Join("testdata", test.name+"."+format)
+				if *update {
+					if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := ioutil.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out.Bytes(), want) {
+					t.Errorf("%s output differs from %s, run go test -update after checking it\n%s", format, golden, out.String())
+				}
+			})
+		}
+	}
+}
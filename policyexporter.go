@@ -3,12 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"regexp"
+	"strings"
 
 	netv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	//	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/labels"
 
 	client "github.com/openshift/network-security-manager/pkg/client"
 
@@ -16,10 +21,17 @@ import (
 	"github.com/landoop/tableprinter"
 )
 
-var counter int
+// Translator translates policies into FirewallPolicies, numbering the rules of all of them in translation order
+type Translator struct {
+	Policies []FirewallPolicy
+	counter  int
+	policy   *FirewallPolicy
+}
 
-var policies []FirewallPolicy
-var firewallPolicy *FirewallPolicy
+// NewTranslator returns a translator numbering its rules from order
+func NewTranslator(order int) *Translator {
+	return &Translator{counter: order}
+}
 
 // check if arr contains str
 func contains(arr []netv1.PolicyType, str netv1.PolicyType) bool {
@@ -101,101 +113,119 @@ func RulePrinter(rule FirewallRule) {
 	fmt.Println(rule.Order, rule.From, rule.To, rule.Action)
 }
 
+// appendRule adds a rule to the current FirewallPolicy with the next order
+func (t *Translator) appendRule(from FirewallLocation, to FirewallLocation, action string, direction netv1.PolicyType) {
+	policy := t.policy.Reference()
+	rule := FirewallRule{From: from, To: to, Action: action, Order: t.counter, Direction: direction, Policy: policy}
+	t.policy.Rules = append(t.policy.Rules, rule)
+	t.counter++
+}
+
+// portsLocation sets the ports of a location, an empty list of ports means all ports
+func portsLocation(location FirewallLocation, ports []netv1.NetworkPolicyPort) FirewallLocation {
+	location.Ports = ports
+	location.AllPorts = len(ports) == 0
+	return location
+}
+
+// selectorLocation returns the namespace qualified location of pods selected from a policy namespace
+// without a namespace selector the pods are in the policy namespace, an empty namespace selector means all namespaces
+func selectorLocation(podSelector *metav1.LabelSelector, namespaceSelector *metav1.LabelSelector, namespace string) FirewallLocation {
+	location := FirewallLocation{PodSelector: podSelector}
+	switch {
+	case namespaceSelector == nil:
+		location.Namespace = namespace
+	case len(namespaceSelector.MatchLabels) == 0 && len(namespaceSelector.MatchExpressions) == 0:
+		location.AllNamespaces = true
+	default:
+		location.NamespaceSelector = namespaceSelector
+	}
+	return location
+}
+
 // IngressTranslator translate ingress rule into FirewallRules
-func IngressTranslator(ingress netv1.NetworkPolicyIngressRule, policy netv1.NetworkPolicy) {
-	var ingressIPRejectRule FirewallRule
+func (t *Translator) IngressTranslator(ingress netv1.NetworkPolicyIngressRule, policy netv1.NetworkPolicy) {
+	target := selectorLocation(&policy.Spec.PodSelector, nil, policy.Namespace)
+
+	// an empty from matches all sources
+	if len(ingress.From) == 0 {
+		t.appendRule(portsLocation(FirewallLocation{Any: true}, ingress.Ports), target, ActionAllow, netv1.PolicyTypeIngress)
+	}
 
 	for _, from := range ingress.From {
 		if from.IPBlock != nil {
 			ipblock := *from.IPBlock
 			for _, except := range ipblock.Except {
 				//fmt.Println(i, "from:", except, "port:", ingress.Ports, "to:", policy.Spec.PodSelector, "action: reject")
-				ingressIPRejectRule = FirewallRule{FirewallLocation{CIDR: except, Ports: ingress.Ports}, FirewallLocation{PodSelector: policy.Spec.PodSelector}, "REJECT", counter}
-				firewallPolicy.Rules = append(firewallPolicy.Rules, ingressIPRejectRule)
-				counter++
+				t.appendRule(portsLocation(FirewallLocation{CIDR: except}, ingress.Ports), target, ActionReject, netv1.PolicyTypeIngress)
 			}
 			//fmt.Println(i, "from:", ipblock.CIDR, "port:", ingress.Ports, "to:", policy.Spec.PodSelector, "action: allow")
-			ingressIPRejectRule = FirewallRule{FirewallLocation{CIDR: ipblock.CIDR, Ports: ingress.Ports}, FirewallLocation{PodSelector: policy.Spec.PodSelector}, "ALLOW", counter}
-			firewallPolicy.Rules = append(firewallPolicy.Rules, ingressIPRejectRule)
-			counter++
-		}
-
-		if from.PodSelector != nil {
-			podselector := *from.PodSelector
-			//			fmt.Println(i, "from:", podselector, "ports:", ingress.Ports, "to:", policy.Spec.PodSelector, "action: allow")
-
-			//			fmt.Println(i, "podselector:", podselector.String())
-
-			ingressIPRejectRule = FirewallRule{FirewallLocation{PodSelector: podselector, Ports: ingress.Ports}, FirewallLocation{PodSelector: policy.Spec.PodSelector}, "ALLOW", counter}
-			firewallPolicy.Rules = append(firewallPolicy.Rules, ingressIPRejectRule)
-			counter++
+			t.appendRule(portsLocation(FirewallLocation{CIDR: ipblock.CIDR, Except: ipblock.Except}, ingress.Ports), target, ActionAllow, netv1.PolicyTypeIngress)
 		}
 
-		if from.NamespaceSelector != nil {
-			namespaceselector := *from.NamespaceSelector
-			//			fmt.Println(i, "from:", namespaceselector, "ports:", ingress.Ports, "to:", policy.Spec.PodSelector, "action: allow")
-			ingressIPRejectRule = FirewallRule{FirewallLocation{NamespaceSelector: namespaceselector, Ports: ingress.Ports}, FirewallLocation{PodSelector: policy.Spec.PodSelector}, "ALLOW", counter}
-			firewallPolicy.Rules = append(firewallPolicy.Rules, ingressIPRejectRule)
-			counter++
+		// a peer with both selectors allows the selected pods of the selected namespaces only, so it stays a single rule
+		if from.PodSelector != nil || from.NamespaceSelector != nil {
+			peer := selectorLocation(from.PodSelector, from.NamespaceSelector, policy.Namespace)
+			t.appendRule(portsLocation(peer, ingress.Ports), target, ActionAllow, netv1.PolicyTypeIngress)
 		}
 	}
 }
 
 // EgressTranslator translate egress rule into FirewallRules
-func EgressTranslator(egress netv1.NetworkPolicyEgressRule, policy netv1.NetworkPolicy) {
-	var egressRule FirewallRule
+func (t *Translator) EgressTranslator(egress netv1.NetworkPolicyEgressRule, policy netv1.NetworkPolicy) {
+	target := selectorLocation(&policy.Spec.PodSelector, nil, policy.Namespace)
+
+	// an empty to matches all destinations
+	if len(egress.To) == 0 {
+		t.appendRule(target, portsLocation(FirewallLocation{Any: true}, egress.Ports), ActionAllow, netv1.PolicyTypeEgress)
+	}
 
 	for _, to := range egress.To {
 		if to.IPBlock != nil {
 			ipblock := *to.IPBlock
 			for _, except := range ipblock.Except {
-				egressRule = FirewallRule{FirewallLocation{PodSelector: policy.Spec.PodSelector}, FirewallLocation{CIDR: except, Ports: egress.Ports}, "REJECT", counter}
-				firewallPolicy.Rules = append(firewallPolicy.Rules, egressRule)
-				counter++
+				t.appendRule(target, portsLocation(FirewallLocation{CIDR: except}, egress.Ports), ActionReject, netv1.PolicyTypeEgress)
 			}
-			egressRule = FirewallRule{FirewallLocation{PodSelector: policy.Spec.PodSelector}, FirewallLocation{CIDR: ipblock.CIDR, Ports: egress.Ports}, "ALLOW", counter}
-			firewallPolicy.Rules = append(firewallPolicy.Rules, egressRule)
-			counter++
-		}
-
-		if to.PodSelector != nil {
-			podselector := *to.PodSelector
-			egressRule = FirewallRule{FirewallLocation{PodSelector: policy.Spec.PodSelector}, FirewallLocation{PodSelector: podselector, Ports: egress.Ports}, "ALLOW", counter}
-			firewallPolicy.Rules = append(firewallPolicy.Rules, egressRule)
-			counter++
+			t.appendRule(target, portsLocation(FirewallLocation{CIDR: ipblock.CIDR, Except: ipblock.Except}, egress.Ports), ActionAllow, netv1.PolicyTypeEgress)
 		}
 
-		if to.NamespaceSelector != nil {
-			namespaceselector := *to.NamespaceSelector
-			egressRule = FirewallRule{FirewallLocation{PodSelector: policy.Spec.PodSelector}, FirewallLocation{NamespaceSelector: namespaceselector, Ports: egress.Ports}, "ALLOW", counter}
-			firewallPolicy.Rules = append(firewallPolicy.Rules, egressRule)
-			counter++
+		// a peer with both selectors allows the selected pods of the selected namespaces only, so it stays a single rule
+		if to.PodSelector != nil || to.NamespaceSelector != nil {
+			peer := selectorLocation(to.PodSelector, to.NamespaceSelector, policy.Namespace)
+			t.appendRule(target, portsLocation(peer, egress.Ports), ActionAllow, netv1.PolicyTypeEgress)
 		}
 	}
 }
 
 // PolicyRulesTranslator translate networkpolicy to FirewallPolicy with FirewallRules
-func PolicyRulesTranslator(policy netv1.NetworkPolicy) {
-	firewallPolicy = new(FirewallPolicy)
-	firewallPolicy.Namespace = policy.ObjectMeta.Namespace
-	firewallPolicy.Name = policy.Name
+func (t *Translator) PolicyRulesTranslator(policy netv1.NetworkPolicy) {
+	t.policy = new(FirewallPolicy)
+	t.policy.Namespace = policy.ObjectMeta.Namespace
+	t.policy.Name = policy.Name
 
-	counter = 0
+	// selected pods are isolated for each policy type, traffic not allowed by a rule ends in the final deny
+	target := selectorLocation(&policy.Spec.PodSelector, nil, policy.Namespace)
+	anywhere := FirewallLocation{Any: true, AllPorts: true}
 
 	if contains(policy.Spec.PolicyTypes, "Ingress") {
 		for _, ingress := range policy.Spec.Ingress {
-			IngressTranslator(ingress, policy)
+			t.IngressTranslator(ingress, policy)
 		}
+		t.appendRule(anywhere, target, ActionDeny, netv1.PolicyTypeIngress)
 	}
 	if contains(policy.Spec.PolicyTypes, "Egress") {
 		for _, egress := range policy.Spec.Egress {
-			EgressTranslator(egress, policy)
+			t.EgressTranslator(egress, policy)
 		}
+		t.appendRule(target, anywhere, ActionDeny, netv1.PolicyTypeEgress)
 	}
 
-	policies = append(policies, *firewallPolicy)
+	t.Policies = append(t.Policies, *t.policy)
+}
 
-	printer := tableprinter.New(os.Stderr)
+// newTablePrinter returns a table printer with the style of the exporter tables
+func newTablePrinter(w io.Writer) *tableprinter.Printer {
+	printer := tableprinter.New(w)
 
 	// Optionally, customize the table, import of the underline 'tablewriter' package is required for that.
 	printer.BorderTop, printer.BorderBottom, printer.BorderLeft, printer.BorderRight = true, true, true, true
@@ -205,30 +235,505 @@ func PolicyRulesTranslator(policy netv1.NetworkPolicy) {
 	printer.HeaderBgColor = tablewriter.BgBlackColor
 	printer.HeaderFgColor = tablewriter.FgGreenColor
 
-	printer.Print(firewallPolicy.Rules)
+	return printer
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+var manifestPaths stringList
+var manifests *Manifests
+var merged bool
+var resolve bool
+var matrixLevel string
+var matrixFormat string
+var querySource string
+var queryDestination string
+var queryPort string
+var queryProtocol string
+var outputFormat string
+var outputFile string
+var zoneGroups stringList
+var zoneFile string
+var diagramLevel string
+var unprotected bool
+var excludeNamespaces string
+var failUnprotected bool
+var analysis string
+var sensitiveSelector string
+var restrictedSelector string
+var snapshotFile string
+var saveSnapshotFile string
+var diffBaseFile string
+var snapshot *Snapshot
+var whatIfPaths stringList
+var whatIfDeletes stringList
+var failBroken bool
+var importFile string
+var verify bool
+var network string
+var clusterCIDRs stringList
+var selectByLabels bool
+
+// readZones returns the zones of the zone definition file and of the zone flags
+func readZones() (*ZoneConfig, error) {
+	zones := new(ZoneConfig)
+	if zoneFile != "" {
+		var err error
+		if zones, err = LoadZoneConfig(zoneFile); err != nil {
+			return nil, err
+		}
+	}
+	groups, err := ParseZoneGroups(zoneGroups)
+	if err != nil {
+		return nil, err
+	}
+	zones.Zones = append(zones.Zones, groups...)
+	return zones, zones.validate()
+}
+
+// TranslatePolicies translates networkpolicies into firewall policies, numbering their rules from 0
+func TranslatePolicies(items []netv1.NetworkPolicy) []FirewallPolicy {
+	return NewTranslator(0).TranslatePolicies(items)
+}
+
+// TranslatePolicies translates networkpolicies after the policies already translated
+func (t *Translator) TranslatePolicies(items []netv1.NetworkPolicy) []FirewallPolicy {
+	for _, policy := range items {
+		t.PolicyRulesTranslator(policy)
+	}
+	return t.Policies
+}
+
+// readPolicies returns the networkpolicies to translate, from manifests when given or from the cluster
+func readPolicies() ([]netv1.NetworkPolicy, error) {
+	if snapshotFile != "" {
+		var err error
+		snapshot, err = LoadSnapshot(snapshotFile)
+		return nil, err
+	}
+
+	if len(manifestPaths) > 0 {
+		var err error
+		manifests, err = LoadManifests(manifestPaths)
+		if err != nil {
+			return nil, err
+		}
+		return manifests.Policies, nil
+	}
+
+	if client.Client == nil {
+		return nil, fmt.Errorf("no cluster client available, check KUBECONFIG or use -f to read manifests")
+	}
+	list, err := client.Client.NetworkPolicies("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// readEgressFirewalls returns the EgressNetworkPolicies and EgressFirewalls, from manifests when given or from the cluster
+func readEgressFirewalls() ([]EgressFirewall, error) {
+	if manifests != nil {
+		return manifests.EgressFirewalls, nil
+	}
+	return LoadEgressFirewalls(client.Client.Client)
+}
+
+// readAdminPolicies returns the AdminNetworkPolicies and BaselineAdminNetworkPolicies, from manifests when given or from the cluster
+func readAdminPolicies() ([]AdminNetworkPolicy, error) {
+	if manifests != nil {
+		return manifests.AdminPolicies, nil
+	}
+	return LoadAdminNetworkPolicies(client.Client.Client)
+}
+
+// readMultiNetworkPolicies returns the MultiNetworkPolicies, from manifests when given or from the cluster
+func readMultiNetworkPolicies() ([]MultiNetworkPolicy, error) {
+	if manifests != nil {
+		return manifests.MultiPolicies, nil
+	}
+	return LoadMultiNetworkPolicies(client.Client.Client)
+}
+
+// readInventory returns the namespaces and pods selectors are resolved against, from manifests when given or from the cluster
+func readInventory() (*Inventory, error) {
+	if snapshot != nil {
+		return snapshot.Inventory, nil
+	}
+	if manifests != nil {
+		return &manifests.Inventory, nil
+	}
+
+	if client.Client == nil {
+		return nil, fmt.Errorf("no cluster client available, check KUBECONFIG")
+	}
+	return LoadInventory(client.Client.CoreV1Interface, client.Client.AppsV1Interface)
 }
 
 func main() {
-	test, err := client.Client.NetworkPolicies("").List(context.Background(), metav1.ListOptions{})
+	flag.Var(&manifestPaths, "f", "NetworkPolicy manifest file or directory to translate instead of the cluster policies, - reads stdin (can be repeated)")
+	flag.BoolVar(&merged, "merged", false, "print a single rulebase merging the rules of all policies in global order")
+	flag.BoolVar(&resolve, "resolve", false, "resolve selectors to the pods and workloads they match and named ports to numbers, from the cluster or the manifests given with -f")
+	flag.StringVar(&matrixLevel, "matrix", "", "print the connectivity matrix between each namespace or workload instead of the rules")
+	flag.StringVar(&matrixFormat, "matrix-format", "table", "format of the connectivity matrix, table, json or csv")
+	flag.StringVar(&querySource, "from", "", "query source, an IP, namespace/pod or namespace:label=value")
+	flag.StringVar(&queryDestination, "to", "", "query destination, an IP, a DNS name, namespace/pod or namespace:label=value")
+	flag.StringVar(&queryPort, "port", "", "query destination port")
+	flag.StringVar(&queryProtocol, "protocol", "TCP", "query protocol")
+	flag.StringVar(&outputFormat, "output", "json", "output format of the rules, one of "+strings.Join(ExportFormats(), ", "))
+	flag.StringVar(&outputFile, "output-file", "", "file to write the output to instead of stdout")
+	flag.BoolVar(&unprotected, "unprotected", false, "list the pods no policy selects for ingress, egress or both instead of the rules, as json, table or csv with -output")
+	flag.StringVar(&excludeNamespaces, "exclude-namespaces", defaultExcludedNamespaces, "pattern of the namespaces left out of the unprotected pods, empty to keep all")
+	flag.BoolVar(&failUnprotected, "fail-unprotected", false, "exit with status 3 when unprotected pods are found")
+	flag.StringVar(&analysis, "analyze", "", "print the findings of an analysis of the rules instead of the rules, permissive or redundant, as json, table or csv with -output")
+	flag.StringVar(&sensitiveSelector, "sensitive-pods", "", "label selector of the sensitive pods flagged when ingress allows all ports to them")
+	flag.StringVar(&restrictedSelector, "restricted-namespaces", "", "label selector of the namespaces flagged when egress allows the internet")
+	flag.StringVar(&snapshotFile, "snapshot", "", "snapshot file to read the policies and inventory from instead of the cluster")
+	flag.StringVar(&saveSnapshotFile, "save-snapshot", "", "save the translated policies and the inventory to a snapshot file instead of printing the rules")
+	flag.StringVar(&diffBaseFile, "diff", "", "snapshot file to compare the policies with, print the rules and connectivity added and removed since")
+	flag.Var(&whatIfPaths, "whatif", "proposed NetworkPolicy manifest file or directory to simulate, adding or replacing policies, print the rules and flows changed instead of the rules (can be repeated)")
+	flag.Var(&whatIfDeletes, "whatif-delete", "namespace/name of a policy to simulate the deletion of (can be repeated)")
+	flag.BoolVar(&failBroken, "fail-broken", false, "exit with status 3 when the simulated changes break flows")
+	flag.StringVar(&importFile, "import", "", "firewall rules to translate back to NetworkPolicy manifests, in the exporter JSON format or CSV (.csv), - reads JSON from stdin")
+	flag.BoolVar(&verify, "verify", false, "check the policies, their firewall rules and the policies regenerated from the rules allow the same connections, print the counterexamples instead of the rules")
+	flag.BoolVar(&selectByLabels, "namespace-labels", false, "select the namespaces of the imported peers by their own labels, for clusters older than Kubernetes 1.21 without the kubernetes.io/metadata.name label")
+	flag.StringVar(&network, "network", "", "namespace/name of a secondary network to export and analyze the MultiNetworkPolicies and attached pods of, instead of the cluster network")
+	flag.StringVar(&diagramLevel, "diagram-level", MatrixWorkload, "level of the dot and mermaid diagrams, workload or namespace")
+	flag.StringVar(&zoneFile, "zones", "", "zone definition file assigning namespaces, node subnets and external CIDRs to firewall zones")
+	flag.Var(&clusterCIDRs, "cluster-cidr", "pod or service CIDR of the cluster, the iptables and nftables outputs only apply egress firewall rules to other destinations (can be repeated)")
+	flag.Var(&zoneGroups, "zone", "firewall zone grouping namespaces as zone=namespace,namespace (can be repeated)")
+	flag.Parse()
+
+	query := querySource != "" || queryDestination != ""
+	if query && (querySource == "" || queryDestination == "" || queryPort == "") {
+		fmt.Fprintln(os.Stderr, "a query needs -from, -to and -port")
+		os.Exit(1)
+	}
+
+	if matrixLevel != "" && matrixLevel != MatrixNamespace && matrixLevel != MatrixWorkload {
+		fmt.Fprintf(os.Stderr, "unknown matrix level %q, use %s or %s\n", matrixLevel, MatrixNamespace, MatrixWorkload)
+		os.Exit(1)
+	}
+
+	if diagramLevel != MatrixNamespace && diagramLevel != MatrixWorkload {
+		fmt.Fprintf(os.Stderr, "unknown diagram level %q, use %s or %s\n", diagramLevel, MatrixNamespace, MatrixWorkload)
+		os.Exit(1)
+	}
+
+	for _, cidr := range clusterCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid cluster CIDR %q\n", cidr)
+			os.Exit(1)
+		}
+	}
+
+	out := io.Writer(os.Stdout)
+	if outputFile != "" {
+		file, err := os.Create(outputFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if importFile != "" {
+		var zones *ZoneConfig
+		if zoneFile != "" || len(zoneGroups) > 0 {
+			var err error
+			if zones, err = readZones(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		rules, err := LoadFirewallRules(importFile, zones)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// the namespaces of the manifests or the cluster let peers select other namespaces by their own labels
+		var inventory *Inventory
+		if selectByLabels {
+			if len(manifestPaths) > 0 {
+				if manifests, err = LoadManifests(manifestPaths); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+			if manifests == nil && client.Client == nil {
+				println("namespaces are selected by their name label, -namespace-labels needs the manifests or the cluster")
+			} else if inventory, err = readInventory(); err != nil {
+				println("namespaces are selected by their name label, no inventory:", err.Error())
+			}
+		}
+		items, findings := ReverseTranslate(rules, inventory)
+		if err := WriteNetworkPolicies(out, items); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(findings) > 0 {
+			println("rules NetworkPolicy cannot express:")
+			PrintFindings(os.Stderr, findings, "table")
+		}
+		return
+	}
+
+	if network != "" && len(strings.SplitN(network, "/", 2)) != 2 {
+		fmt.Fprintf(os.Stderr, "invalid network %q, use the namespace/name of its NetworkAttachmentDefinition\n", network)
+		os.Exit(1)
+	}
+	if network != "" && verify {
+		fmt.Fprintln(os.Stderr, "-verify checks the NetworkPolicies of the cluster network, not -network")
+		os.Exit(1)
+	}
+
+	items, err := readPolicies()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var policies []FirewallPolicy
+	if snapshot != nil {
+		if verify {
+			fmt.Fprintln(os.Stderr, "-verify needs the NetworkPolicies, a snapshot only holds their rules")
+			os.Exit(1)
+		}
+		policies = snapshot.Policies
+	} else {
+		translator := NewTranslator(0)
+		println("Network Policies:")
+		if len(items) == 0 {
+			println("no policies")
+		}
+		for i, policy := range items {
+			println(i, ":", policy.Name)
+		}
+		translator.TranslatePolicies(items)
+
+		firewalls, err := readEgressFirewalls()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		translator.TranslateEgressFirewalls(firewalls)
+
+		adminPolicies, err := readAdminPolicies()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		translator.TranslateAdminNetworkPolicies(adminPolicies)
+
+		multiPolicies, err := readMultiNetworkPolicies()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		policies = translator.TranslateMultiNetworkPolicies(multiPolicies)
+	}
+
+	if analysis != "" && analysis != "permissive" && analysis != "redundant" {
+		fmt.Fprintf(os.Stderr, "unknown analysis %q, use permissive or redundant\n", analysis)
+		os.Exit(1)
+	}
+	sensitive, err := labels.Parse(sensitiveSelector)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	restricted, err := labels.Parse(restrictedSelector)
 	if err != nil {
-		fmt.Println("err")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	println("Network Policies:")
-	if len(test.Items) == 0 {
-		fmt.Printf("no policies\n")
+	// an empty pattern would match every namespace, it keeps them all
+	var exclude *regexp.Regexp
+	if excludeNamespaces != "" {
+		if exclude, err = regexp.Compile(excludeNamespaces); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
 
-	for i, policy := range test.Items {
-		println(i, ":", policy.Name)
-		//PolicyPrinter(policy)
-		PolicyRulesTranslator(policy)
+	whatIf := len(whatIfPaths) > 0 || len(whatIfDeletes) > 0
+	var proposed []FirewallPolicy
+	if whatIf {
+		var changes []netv1.NetworkPolicy
+		if len(whatIfPaths) > 0 {
+			loaded, err := LoadManifests(whatIfPaths)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			changes = loaded.Policies
+		}
+		proposed, err = ProposePolicies(policies, changes, whatIfDeletes)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
+	var inventory *Inventory
+	if resolve || matrixLevel != "" || query || unprotected || analysis != "" || saveSnapshotFile != "" || diffBaseFile != "" || whatIf || verify || resolvingFormats[outputFormat] {
+		inventory, err = readInventory()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		ResolveWorkloads(policies, inventory)
+		ResolveNamedPorts(policies, inventory)
+		ResolveWorkloads(proposed, inventory)
+		ResolveNamedPorts(proposed, inventory)
 	}
 
-	json, err := json.Marshal(policies)
+	if zoneFile != "" || len(zoneGroups) > 0 || zonedFormats[outputFormat] {
+		zones, err := readZones()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		AssignZones(policies, zones, inventory)
+		AssignZones(proposed, zones, inventory)
+	}
+
+	if saveSnapshotFile != "" {
+		if err := SaveSnapshot(saveSnapshotFile, policies, inventory); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		println("snapshot saved to", saveSnapshotFile)
+		return
+	}
+
+	// from here on only the policies and pods of the secondary network are exported and analyzed
+	if network != "" {
+		policies = FilterNetwork(policies, network)
+		proposed = FilterNetwork(proposed, network)
+		if inventory != nil {
+			inventory = inventory.OnNetwork(network)
+		}
+	}
+
+	if diffBaseFile != "" {
+		base, err := LoadSnapshot(diffBaseFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if network != "" {
+			base.Policies, base.Inventory = FilterNetwork(base.Policies, network), base.Inventory.OnNetwork(network)
+		}
+		if err := PrintDiff(out, DiffPolicies(base.Policies, base.Inventory, policies, inventory), outputFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if verify {
+		result := VerifyRoundTrip(items, inventory)
+		if err := PrintVerification(out, result, outputFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(result.Counterexamples) > 0 {
+			os.Exit(3)
+		}
+		return
+	}
+
+	if whatIf {
+		result := SimulatePolicies(policies, proposed, inventory)
+		if err := PrintWhatIf(out, result, outputFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if failBroken && len(result.Broken) > 0 {
+			os.Exit(3)
+		}
+		return
+	}
+
+	if query {
+		source, err := ParseEndpoint(querySource, inventory)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		destination, err := ParseEndpoint(queryDestination, inventory)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		port := strings.ToUpper(queryProtocol) + "/" + queryPort
+		PrintQueryResult(out, Query(policies, source, destination, port))
+		return
+	}
+
+	if analysis != "" {
+		var findings []Finding
+		switch analysis {
+		case "permissive":
+			findings = AnalyzePermissive(policies, inventory, PermissiveOptions{Sensitive: sensitive, Restricted: restricted})
+		case "redundant":
+			findings = AnalyzeRedundant(policies)
+		default:
+			fmt.Fprintf(os.Stderr, "unknown analysis %q, use permissive or redundant\n", analysis)
+			os.Exit(1)
+		}
+		if err := PrintFindings(out, findings, outputFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if unprotected {
+		namespaces := AuditUnprotected(policies, inventory, exclude)
+		if err := PrintUnprotected(out, namespaces, outputFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if failUnprotected && len(namespaces) > 0 {
+			os.Exit(3)
+		}
+		return
+	}
+
+	if matrixLevel != "" {
+		if err := PrintMatrix(out, ConnectivityMatrix(policies, inventory, matrixLevel), matrixFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	exporter, err := NewExporter(outputFormat, ExportOptions{Inventory: inventory, Level: diagramLevel, Merged: merged, Exclude: exclude, ClusterCIDRs: clusterCIDRs})
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	exported := policies
+	if merged {
+		exported = []FirewallPolicy{{Name: mergedRulebaseName, Rules: MergeRulebase(policies)}}
+	}
+	if err := exporter.Export(out, exported); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	fmt.Println(string(json))
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestPolicyRulesTranslator(t *testing.T) {
+	web := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	teamA := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+	tcp := corev1.ProtocolTCP
+	http := intstr.FromInt(80)
+	ports := []netv1.NetworkPolicyPort{{Protocol: &tcp, Port: &http}}
+
+	target := FirewallLocation{Namespace: "ns", PodSelector: &metav1.LabelSelector{}}
+	anywhere := FirewallLocation{Any: true, AllPorts: true}
+
+	tests := []struct {
+		name    string
+		types   []netv1.PolicyType
+		ingress []netv1.NetworkPolicyIngressRule
+		egress  []netv1.NetworkPolicyEgressRule
+		want    []FirewallRule
+	}{
+		{
+			name:    "ingress peer with both selectors",
+			types:   []netv1.PolicyType{netv1.PolicyTypeIngress},
+			ingress: []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{PodSelector: web, NamespaceSelector: teamA}}}},
+			want: []FirewallRule{
+				{From: portsLocation(FirewallLocation{PodSelector: web, NamespaceSelector: teamA}, nil), To: target, Action: ActionAllow, Direction: netv1.PolicyTypeIngress},
+				{From: anywhere, To: target, Action: ActionDeny, Direction: netv1.PolicyTypeIngress},
+			},
+		},
+		{
+			name:   "egress peer with both selectors",
+			types:  []netv1.PolicyType{netv1.PolicyTypeEgress},
+			egress: []netv1.NetworkPolicyEgressRule{{To: []netv1.NetworkPolicyPeer{{PodSelector: web, NamespaceSelector: teamA}}, Ports: ports}},
+			want: []FirewallRule{
+				{From: target, To: portsLocation(FirewallLocation{PodSelector: web, NamespaceSelector: teamA}, ports), Action: ActionAllow, Direction: netv1.PolicyTypeEgress},
+				{From: target, To: anywhere, Action: ActionDeny, Direction: netv1.PolicyTypeEgress},
+			},
+		},
+		{
+			name:  "peers with a single selector",
+			types: []netv1.PolicyType{netv1.PolicyTypeIngress},
+			ingress: []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{
+				{PodSelector: web},
+				{NamespaceSelector: teamA},
+				{NamespaceSelector: &metav1.LabelSelector{}},
+			}}},
+			want: []FirewallRule{
+				{From: portsLocation(FirewallLocation{Namespace: "ns", PodSelector: web}, nil), To: target, Action: ActionAllow, Direction: netv1.PolicyTypeIngress},
+				{From: portsLocation(FirewallLocation{NamespaceSelector: teamA}, nil), To: target, Action: ActionAllow, Direction: netv1.PolicyTypeIngress},
+				{From: portsLocation(FirewallLocation{AllNamespaces: true}, nil), To: target, Action: ActionAllow, Direction: netv1.PolicyTypeIngress},
+				{From: anywhere, To: target, Action: ActionDeny, Direction: netv1.PolicyTypeIngress},
+			},
+		},
+		{
+			name:  "ipBlock with except",
+			types: []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress},
+			ingress: []netv1.NetworkPolicyIngressRule{{
+				From:  []netv1.NetworkPolicyPeer{{IPBlock: &netv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16", "10.2.0.0/16"}}}},
+				Ports: ports,
+			}},
+			egress: []netv1.NetworkPolicyEgressRule{{To: []netv1.NetworkPolicyPeer{{IPBlock: &netv1.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"169.254.0.0/16"}}}}}},
+			want: []FirewallRule{
+				{From: portsLocation(FirewallLocation{CIDR: "10.1.0.0/16"}, ports), To: target, Action: ActionReject, Direction: netv1.PolicyTypeIngress},
+				{From: portsLocation(FirewallLocation{CIDR: "10.2.0.0/16"}, ports), To: target, Action: ActionReject, Direction: netv1.PolicyTypeIngress},
+				{From: portsLocation(FirewallLocation{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16", "10.2.0.0/16"}}, ports), To: target, Action: ActionAllow, Direction: netv1.PolicyTypeIngress},
+				{From: anywhere, To: target, Action: ActionDeny, Direction: netv1.PolicyTypeIngress},
+				{From: target, To: portsLocation(FirewallLocation{CIDR: "169.254.0.0/16"}, nil), Action: ActionReject, Direction: netv1.PolicyTypeEgress},
+				{From: target, To: portsLocation(FirewallLocation{CIDR: "0.0.0.0/0", Except: []string{"169.254.0.0/16"}}, nil), Action: ActionAllow, Direction: netv1.PolicyTypeEgress},
+				{From: target, To: anywhere, Action: ActionDeny, Direction: netv1.PolicyTypeEgress},
+			},
+		},
+		{
+			name:    "empty from and to",
+			types:   []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress},
+			ingress: []netv1.NetworkPolicyIngressRule{{}},
+			egress:  []netv1.NetworkPolicyEgressRule{{Ports: ports}},
+			want: []FirewallRule{
+				{From: anywhere, To: target, Action: ActionAllow, Direction: netv1.PolicyTypeIngress},
+				{From: anywhere, To: target, Action: ActionDeny, Direction: netv1.PolicyTypeIngress},
+				{From: target, To: portsLocation(FirewallLocation{Any: true}, ports), Action: ActionAllow, Direction: netv1.PolicyTypeEgress},
+				{From: target, To: anywhere, Action: ActionDeny, Direction: netv1.PolicyTypeEgress},
+			},
+		},
+		{
+			name:  "no rules isolate",
+			types: []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress},
+			want: []FirewallRule{
+				{From: anywhere, To: target, Action: ActionDeny, Direction: netv1.PolicyTypeIngress},
+				{From: target, To: anywhere, Action: ActionDeny, Direction: netv1.PolicyTypeEgress},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := netv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "np"},
+				Spec:       netv1.NetworkPolicySpec{PolicyTypes: test.types, Ingress: test.ingress, Egress: test.egress},
+			}
+			translated := TranslatePolicies([]netv1.NetworkPolicy{policy})
+			if len(translated) != 1 {
+				t.Fatalf("got %d policies, want 1", len(translated))
+			}
+
+			for i := range test.want {
+				test.want[i].Order = i
+				test.want[i].Policy = PolicyReference{Namespace: "ns", Name: "np"}
+			}
+			if got := translated[0].Rules; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got rules\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+// ruleLine flattens a rule as direction, action, source, destination and ports for the tests
+func ruleLine(rule FirewallRule) string {
+	return fmt.Sprintf("%s %s %s %s %s", rule.Direction, rule.Action, FormatLocation(rule.From), FormatLocation(rule.To), FormatPorts(*rule.PortsLocation()))
+}
+
+func TestImplicitRules(t *testing.T) {
+	web := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	api := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}
+	http := intstr.FromInt(80)
+
+	tests := []struct {
+		name    string
+		types   []netv1.PolicyType
+		ingress []netv1.NetworkPolicyIngressRule
+		egress  []netv1.NetworkPolicyEgressRule
+		want    []string
+	}{
+		{
+			name:  "ingress isolation without rules",
+			types: []netv1.PolicyType{netv1.PolicyTypeIngress},
+			want:  []string{"Ingress DENY any ns/app=web ANY"},
+		},
+		{
+			name:  "egress isolation leaves ingress open",
+			types: []netv1.PolicyType{netv1.PolicyTypeEgress},
+			want:  []string{"Egress DENY ns/app=web any ANY"},
+		},
+		{
+			name:    "empty from allows any source on the listed ports",
+			types:   []netv1.PolicyType{netv1.PolicyTypeIngress},
+			ingress: []netv1.NetworkPolicyIngressRule{{Ports: []netv1.NetworkPolicyPort{{Port: &http}}}},
+			want:    []string{"Ingress ALLOW any ns/app=web TCP/80", "Ingress DENY any ns/app=web ANY"},
+		},
+		{
+			name:    "empty ports allow every port",
+			types:   []netv1.PolicyType{netv1.PolicyTypeIngress},
+			ingress: []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{PodSelector: api}}}},
+			want:    []string{"Ingress ALLOW ns/app=api ns/app=web ANY", "Ingress DENY any ns/app=web ANY"},
+		},
+		{
+			name:   "empty to allows any destination",
+			types:  []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress},
+			egress: []netv1.NetworkPolicyEgressRule{{}},
+			want:   []string{"Ingress DENY any ns/app=web ANY", "Egress ALLOW ns/app=web any ANY", "Egress DENY ns/app=web any ANY"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := netv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web"},
+				Spec:       netv1.NetworkPolicySpec{PodSelector: *web, PolicyTypes: test.types, Ingress: test.ingress, Egress: test.egress},
+			}
+			defaultPolicy(&policy)
+
+			var got []string
+			for _, rule := range TranslatePolicies([]netv1.NetworkPolicy{policy})[0].Rules {
+				got = append(got, ruleLine(rule))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got rules\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestNamespaceScope(t *testing.T) {
+	web := metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	api := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}
+	teamA := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+
+	tests := []struct {
+		name string
+		peer netv1.NetworkPolicyPeer
+		want string
+	}{
+		{name: "pod selector alone is in the policy namespace", peer: netv1.NetworkPolicyPeer{PodSelector: api}, want: "shop/app=api"},
+		{name: "empty namespace selector is every namespace", peer: netv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{}}, want: "*/*"},
+		{name: "pods of every namespace", peer: netv1.NetworkPolicyPeer{PodSelector: api, NamespaceSelector: &metav1.LabelSelector{}}, want: "*/app=api"},
+		{name: "namespace selector", peer: netv1.NetworkPolicyPeer{NamespaceSelector: teamA}, want: "{team=a}/*"},
+		{name: "pods of selected namespaces", peer: netv1.NetworkPolicyPeer{PodSelector: api, NamespaceSelector: teamA}, want: "{team=a}/app=api"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var policies []netv1.NetworkPolicy
+			for _, namespace := range []string{"shop", "db"} {
+				policies = append(policies, netv1.NetworkPolicy{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "web"},
+					Spec: netv1.NetworkPolicySpec{
+						PodSelector: web,
+						PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+						Ingress:     []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{test.peer}}},
+					},
+				})
+			}
+			translated := TranslatePolicies(policies)
+
+			// the same selectors in another namespace are told apart by their namespace
+			shop, db := translated[0].Rules[0], translated[1].Rules[0]
+			if got := FormatLocation(shop.From); got != test.want {
+				t.Errorf("got peer %s, want %s", got, test.want)
+			}
+			if got := FormatLocation(shop.To); got != "shop/app=web" {
+				t.Errorf("got target %s, want shop/app=web", got)
+			}
+			if got := FormatLocation(db.To); got != "db/app=web" {
+				t.Errorf("got target %s in the other namespace, want db/app=web", got)
+			}
+			if test.peer.NamespaceSelector == nil && FormatLocation(db.From) == FormatLocation(shop.From) {
+				t.Errorf("peer %s is the same in both namespaces", FormatLocation(db.From))
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// PortsLocation returns the location of a rule holding its ports, the peer of the policy pods
+func (r *FirewallRule) PortsLocation() *FirewallLocation {
+	if r.Direction == netv1.PolicyTypeIngress {
+		return &r.From
+	}
+	return &r.To
+}
+
+// ResolveNamedPorts translates the named ports of every rule to the container ports of its target pods
+// a rule is split when target pods map the same name to different numbers
+func ResolveNamedPorts(policies []FirewallPolicy, inventory *Inventory) {
+	for i := range policies {
+		var rules []FirewallRule
+		for _, rule := range policies[i].Rules {
+			rules = append(rules, inventory.OnNetwork(rule.To.Network).resolveRulePorts(rule)...)
+		}
+		policies[i].Rules = rules
+	}
+}
+
+// portMapping groups the target pods resolving named ports to the same numbers
+type portMapping struct {
+	ports []netv1.NetworkPolicyPort
+	pods  []corev1.Pod
+}
+
+// resolveRulePorts returns one rule per distinct mapping of the named ports of a rule
+// split rules keep the order of the original rule and only hold the workloads of their mapping
+func (inv *Inventory) resolveRulePorts(rule FirewallRule) []FirewallRule {
+	ports := rule.PortsLocation().Ports
+	if !hasNamedPorts(ports) {
+		return []FirewallRule{rule}
+	}
+
+	var mappings []*portMapping
+	index := map[string]*portMapping{}
+	resolved := map[string]bool{}
+	for _, pod := range inv.targetPods(rule.To) {
+		podPorts := resolvePodPorts(ports, pod, resolved)
+		if len(podPorts) == 0 {
+			continue
+		}
+		key := portsKey(podPorts)
+		if index[key] == nil {
+			index[key] = &portMapping{ports: podPorts}
+			mappings = append(mappings, index[key])
+		}
+		index[key].pods = append(index[key].pods, pod)
+	}
+
+	var unresolved []string
+	for _, port := range ports {
+		if port.Port != nil && port.Port.Type == intstr.String && !resolved[portKey(port)] {
+			unresolved = append(unresolved, portKey(port))
+		}
+	}
+
+	if len(mappings) == 0 {
+		rule.PortsLocation().UnresolvedPorts = unresolved
+		return []FirewallRule{rule}
+	}
+
+	var rules []FirewallRule
+	for _, mapping := range mappings {
+		split := rule
+		split.PortsLocation().Ports = mapping.ports
+		split.PortsLocation().UnresolvedPorts = unresolved
+		split.To.Workloads = nil
+		for _, pod := range mapping.pods {
+			split.To.Workloads = append(split.To.Workloads, inv.Workload(pod))
+		}
+		rules = append(rules, split)
+	}
+	return rules
+}
+
+// targetPods returns the pods a rule destination can be, named ports never resolve on CIDRs
+func (inv *Inventory) targetPods(location FirewallLocation) []corev1.Pod {
+	if location.Any {
+		return inv.NetworkPods()
+	}
+	return inv.SelectPods(location)
+}
+
+// resolvePodPorts returns the ports of a rule with the named ports defined by a pod translated to numbers
+// names the pod does not define are left out and the resolved names are recorded
+func resolvePodPorts(ports []netv1.NetworkPolicyPort, pod corev1.Pod, resolved map[string]bool) []netv1.NetworkPolicyPort {
+	var podPorts []netv1.NetworkPolicyPort
+	for _, port := range ports {
+		if port.Port == nil || port.Port.Type == intstr.Int {
+			podPorts = append(podPorts, port)
+			continue
+		}
+
+		containerPort, ok := findContainerPort(pod, port.Port.StrVal, portProtocol(port))
+		if !ok {
+			continue
+		}
+		resolved[portKey(port)] = true
+		number := intstr.FromInt(int(containerPort.ContainerPort))
+		protocol := portProtocol(port)
+		podPorts = append(podPorts, netv1.NetworkPolicyPort{Protocol: &protocol, Port: &number})
+	}
+	return podPorts
+}
+
+// findContainerPort returns the container port of a pod with the given name and protocol
+func findContainerPort(pod corev1.Pod, name string, protocol corev1.Protocol) (corev1.ContainerPort, bool) {
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			containerProtocol := containerPort.Protocol
+			if containerProtocol == "" {
+				containerProtocol = corev1.ProtocolTCP
+			}
+			if containerPort.Name == name && containerProtocol == protocol {
+				return containerPort, true
+			}
+		}
+	}
+	return corev1.ContainerPort{}, false
+}
+
+// hasNamedPorts reports if a list of ports holds a named port
+func hasNamedPorts(ports []netv1.NetworkPolicyPort) bool {
+	for _, port := range ports {
+		if port.Port != nil && port.Port.Type == intstr.String {
+			return true
+		}
+	}
+	return false
+}
+
+// portProtocol returns the protocol of a port, TCP when unset
+func portProtocol(port netv1.NetworkPolicyPort) corev1.Protocol {
+	if port.Protocol == nil {
+		return corev1.ProtocolTCP
+	}
+	return *port.Protocol
+}
+
+// portKey formats a port as protocol/port, protocol/name for named ports and protocol alone for all ports of a protocol
+func portKey(port netv1.NetworkPolicyPort) string {
+	if port.Port == nil {
+		return string(portProtocol(port))
+	}
+	return fmt.Sprintf("%s/%s", portProtocol(port), port.Port.String())
+}
+
+// portsKey formats a list of ports in a stable way
+func portsKey(ports []netv1.NetworkPolicyPort) string {
+	var keys []string
+	for _, port := range ports {
+		keys = append(keys, portKey(port))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestResolveRulePorts(t *testing.T) {
+	pod := func(name string, ports ...corev1.ContainerPort) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{"app": "web"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Ports: ports}}},
+		}
+	}
+	named := func(name string, number int32) corev1.ContainerPort {
+		return corev1.ContainerPort{Name: name, ContainerPort: number}
+	}
+	port := func(value intstr.IntOrString) netv1.NetworkPolicyPort {
+		return netv1.NetworkPolicyPort{Port: &value}
+	}
+
+	// split is the part of a resolved rule the tests check
+	type split struct {
+		ports      string
+		unresolved []string
+		pods       []string
+	}
+
+	tests := []struct {
+		name  string
+		pods  []corev1.Pod
+		ports []netv1.NetworkPolicyPort
+		want  []split
+	}{
+		{
+			name:  "numeric ports only",
+			pods:  []corev1.Pod{pod("web-0", named("http", 8080))},
+			ports: []netv1.NetworkPolicyPort{port(intstr.FromInt(80))},
+			want:  []split{{ports: "TCP/80"}},
+		},
+		{
+			name:  "name mapped to the same number",
+			pods:  []corev1.Pod{pod("web-0", named("http", 8080)), pod("web-1", named("http", 8080))},
+			ports: []netv1.NetworkPolicyPort{port(intstr.FromString("http"))},
+			want:  []split{{ports: "TCP/8080", pods: []string{"web-0", "web-1"}}},
+		},
+		{
+			name:  "name mapped to different numbers across pods",
+			pods:  []corev1.Pod{pod("web-0", named("http", 8080)), pod("web-1", named("http", 9090)), pod("web-2", named("http", 8080))},
+			ports: []netv1.NetworkPolicyPort{port(intstr.FromString("http"))},
+			want: []split{
+				{ports: "TCP/8080", pods: []string{"web-0", "web-2"}},
+				{ports: "TCP/9090", pods: []string{"web-1"}},
+			},
+		},
+		{
+			name:  "name no pod defines",
+			pods:  []corev1.Pod{pod("web-0", named("http", 8080))},
+			ports: []netv1.NetworkPolicyPort{port(intstr.FromString("metrics"))},
+			want:  []split{{ports: "TCP/metrics", unresolved: []string{"TCP/metrics"}}},
+		},
+		{
+			name:  "name defined by some pods only",
+			pods:  []corev1.Pod{pod("web-0", named("metrics", 9100)), pod("web-1")},
+			ports: []netv1.NetworkPolicyPort{port(intstr.FromString("metrics"))},
+			want:  []split{{ports: "TCP/9100", pods: []string{"web-0"}}},
+		},
+		{
+			name:  "mixed numeric and named ports",
+			pods:  []corev1.Pod{pod("web-0", named("http", 8080)), pod("web-1", named("http", 8443)), pod("web-2")},
+			ports: []netv1.NetworkPolicyPort{port(intstr.FromInt(443)), port(intstr.FromString("http")), port(intstr.FromString("admin"))},
+			want: []split{
+				{ports: "TCP/443,TCP/8080", unresolved: []string{"TCP/admin"}, pods: []string{"web-0"}},
+				{ports: "TCP/443,TCP/8443", unresolved: []string{"TCP/admin"}, pods: []string{"web-1"}},
+				{ports: "TCP/443", unresolved: []string{"TCP/admin"}, pods: []string{"web-2"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inventory := &Inventory{Pods: test.pods}
+			web := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+			rule := FirewallRule{
+				From:      FirewallLocation{Any: true, Ports: test.ports},
+				To:        FirewallLocation{Namespace: "ns", PodSelector: web},
+				Action:    ActionAllow,
+				Order:     3,
+				Direction: netv1.PolicyTypeIngress,
+			}
+
+			var got []split
+			for _, resolved := range inventory.resolveRulePorts(rule) {
+				if resolved.Order != rule.Order {
+					t.Errorf("split rule has order %d, want %d", resolved.Order, rule.Order)
+				}
+				s := split{ports: FormatPorts(*resolved.PortsLocation()), unresolved: resolved.From.UnresolvedPorts}
+				for _, workload := range resolved.To.Workloads {
+					s.pods = append(s.pods, workload.Pod)
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ParseEndpoint reads an endpoint given as an IP, a namespace/pod name or namespace:pod labels
+// IPs of known pods are resolved to their pod
+func ParseEndpoint(value string, inventory *Inventory) (Endpoint, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		for _, pod := range inventory.NetworkPods() {
+			for _, podIP := range inventory.Workload(pod).IPs {
+				if net.ParseIP(podIP).Equal(ip) {
+					return inventory.podEndpoint(pod), nil
+				}
+			}
+		}
+		return Endpoint{Name: value, IPs: []string{value}, Network: inventory.Network}, nil
+	}
+
+	if parts := strings.SplitN(value, ":", 2); len(parts) == 2 {
+		podLabels, err := labels.ConvertSelectorToLabelsMap(parts[1])
+		if err != nil {
+			return Endpoint{}, fmt.Errorf("invalid pod labels in %q: %v", value, err)
+		}
+		return Endpoint{
+			Namespace:       parts[0],
+			Name:            value,
+			NamespaceLabels: inventory.NamespaceLabels(parts[0]),
+			Labels:          podLabels,
+			Network:         inventory.Network,
+		}, nil
+	}
+
+	if parts := strings.SplitN(value, "/", 2); len(parts) == 2 {
+		for _, pod := range inventory.NetworkPods() {
+			if pod.Namespace == parts[0] && pod.Name == parts[1] {
+				return inventory.podEndpoint(pod), nil
+			}
+		}
+		return Endpoint{}, fmt.Errorf("pod %q not found", value)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(value, "*.")); len(errs) == 0 && strings.Contains(value, ".") {
+		return Endpoint{Name: value, Network: inventory.Network}, nil
+	}
+
+	return Endpoint{}, fmt.Errorf("invalid endpoint %q, use an IP, a DNS name, namespace/pod or namespace:label=value", value)
+}
+
+// QueryResult answers if a source can reach a destination on a port and why
+type QueryResult struct {
+	Source      Endpoint
+	Destination Endpoint
+	Port        string
+	Allowed     bool
+	Egress      SideResult
+	Ingress     SideResult
+}
+
+// SideResult explains the verdict of one side of a connection
+// Rules are the allow rules letting the port through, or the reject rules of except blocks matching the peer
+// Admin and Baseline are the rules of the admin tiers matching the port first, and EgressFirewall the egress
+// firewall rule deciding the port for an external destination
+type SideResult struct {
+	Direction      netv1.PolicyType
+	Allowed        bool
+	Isolated       bool
+	Isolating      []PolicyReference
+	Rules          []FirewallRule
+	Admin          *FirewallRule
+	Baseline       *FirewallRule
+	EgressFirewall *FirewallRule
+}
+
+// Query evaluates if a source can reach a destination on a protocol/port key
+func Query(policies []FirewallPolicy, source Endpoint, destination Endpoint, port string) QueryResult {
+	connection := EvaluateConnection(policies, source, destination)
+	result := QueryResult{
+		Source:      source,
+		Destination: destination,
+		Port:        port,
+		Egress:      sideResult(netv1.PolicyTypeEgress, connection.Egress, port),
+		Ingress:     sideResult(netv1.PolicyTypeIngress, connection.Ingress, port),
+	}
+	result.Allowed = result.Egress.Allowed && result.Ingress.Allowed
+	return result
+}
+
+// sideResult keeps the rules of a verdict that apply to a port
+func sideResult(direction netv1.PolicyType, verdict Verdict, port string) SideResult {
+	side := SideResult{Direction: direction, Isolated: verdict.Isolated, Isolating: verdict.Isolating}
+	side.Admin = firstMatch(verdict.Admin, port)
+	if side.Admin != nil && side.Admin.Action != ActionPass {
+		side.Allowed = side.Admin.Action == ActionAllow
+	} else {
+		for _, rule := range verdict.Rules {
+			if rulePorts(rule).Contains(port) {
+				side.Rules = append(side.Rules, rule)
+			}
+		}
+		side.Allowed = !side.Isolated || len(side.Rules) > 0
+
+		if !side.Isolated {
+			side.Baseline = firstMatch(verdict.Baseline, port)
+			side.Allowed = side.Baseline == nil || side.Baseline.Action == ActionAllow
+		}
+		if !side.Allowed {
+			for _, rule := range verdict.Rejected {
+				if rulePorts(rule).Contains(port) {
+					side.Rules = append(side.Rules, rule)
+				}
+			}
+		}
+	}
+
+	side.EgressFirewall = firstMatch(verdict.EgressFirewall, port)
+	if side.EgressFirewall != nil {
+		side.Allowed = side.Allowed && side.EgressFirewall.Action == ActionAllow
+	}
+	return side
+}
+
+// firstMatch returns the first of ordered rules whose ports contain a port
+func firstMatch(rules []FirewallRule, port string) *FirewallRule {
+	for i, rule := range rules {
+		if rulePorts(rule).Contains(port) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// PrintQueryResult writes a query result for humans
+func PrintQueryResult(w io.Writer, result QueryResult) {
+	verdict := "DENIED"
+	if result.Allowed {
+		verdict = "ALLOWED"
+	}
+	fmt.Fprintf(w, "%s %s -> %s %s\n", verdict, endpointName(result.Source), endpointName(result.Destination), result.Port)
+
+	for _, side := range []SideResult{result.Egress, result.Ingress} {
+		verdict := "denied"
+		if side.Allowed {
+			verdict = "allowed"
+		}
+		switch {
+		case side.Admin != nil && side.Admin.Action != ActionPass:
+			fmt.Fprintf(w, "  %s: %s by the admin tier\n", side.Direction, verdict)
+		case !side.Isolated:
+			fmt.Fprintf(w, "  %s: %s, not isolated by any policy\n", side.Direction, verdict)
+		default:
+			fmt.Fprintf(w, "  %s: %s, isolated by %s\n", side.Direction, verdict, formatReferences(side.Isolating))
+		}
+		if side.Admin != nil {
+			fmt.Fprintf(w, "    %s\n", formatRule(*side.Admin))
+		}
+		for _, rule := range side.Rules {
+			fmt.Fprintf(w, "    %s\n", formatRule(rule))
+		}
+		if side.Baseline != nil {
+			fmt.Fprintf(w, "    %s\n", formatRule(*side.Baseline))
+		}
+		if rule := side.EgressFirewall; rule != nil {
+			fmt.Fprintf(w, "  egress firewall: %s by %s rule %d to %s ports %s\n", strings.ToLower(rule.Action), rule.Policy, rule.Order,
+				FormatLocation(rule.To), FormatPorts(*rule.PortsLocation()))
+		}
+	}
+}
+
+// formatRule describes a rule with its policy, its tier and priority for admin tier rules
+// and its network for secondary network rules
+func formatRule(rule FirewallRule) string {
+	description := fmt.Sprintf("%s rule %d %s from %s to %s ports %s", rule.Policy, rule.Order, rule.Action,
+		FormatLocation(rule.From), FormatLocation(rule.To), FormatPorts(*rule.PortsLocation()))
+	switch rule.Tier {
+	case TierAdmin:
+		description += fmt.Sprintf(" (admin tier, priority %d)", rule.Priority)
+	case TierBaseline:
+		description += " (baseline tier)"
+	}
+	if rule.To.Network != "" {
+		description += " on network " + rule.To.Network
+	}
+	return description
+}
+
+// endpointName returns the name of an endpoint as given to a query
+func endpointName(endpoint Endpoint) string {
+	if !endpoint.IsPod() || strings.HasPrefix(endpoint.Name, endpoint.Namespace+":") {
+		return endpoint.Name
+	}
+	return endpoint.Namespace + "/" + endpoint.Name
+}
+
+// formatReferences joins policy references
+func formatReferences(references []PolicyReference) string {
+	var names []string
+	seen := map[PolicyReference]bool{}
+	for _, reference := range references {
+		if !seen[reference] {
+			seen[reference] = true
+			names = append(names, reference.String())
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", "v.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory := &manifests.Inventory
+	policies := TranslatePolicies(manifests.Policies)
+	ResolveWorkloads(policies, inventory)
+	ResolveNamedPorts(policies, inventory)
+
+	tests := []struct {
+		from    string
+		to      string
+		port    string
+		allowed bool
+		egress  bool
+		ingress bool
+		// rules are the actions of the ingress rules the answer names
+		rules []string
+	}{
+		{from: "edge/lb-1", to: "shop/web-0", port: "TCP/8080", allowed: true, egress: true, ingress: true, rules: []string{ActionAllow, ActionAllow}},
+		{from: "edge:app=lb", to: "10.128.1.7", port: "TCP/9443", allowed: true, egress: true, ingress: true, rules: []string{ActionAllow}},
+		{from: "edge/lb-1", to: "shop/web-0", port: "TCP/22", egress: true},
+		{from: "10.2.0.1", to: "shop/web-0", port: "TCP/8080", allowed: true, egress: true, ingress: true, rules: []string{ActionAllow}},
+		{from: "10.1.2.3", to: "shop/web-0", port: "TCP/8080", egress: true, rules: []string{ActionReject}},
+		{from: "shop/web-0", to: "db/pg-0", port: "TCP/5432", allowed: true, egress: true, ingress: true, rules: []string{ActionAllow}},
+		{from: "db/pg-0", to: "shop/web-0", port: "TCP/8080", ingress: true, rules: []string{ActionAllow}},
+		{from: "db/pg-0", to: "10.96.0.10", port: "UDP/53", allowed: true, egress: true, ingress: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.from+" to "+test.to+" "+test.port, func(t *testing.T) {
+			source, err := ParseEndpoint(test.from, inventory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			destination, err := ParseEndpoint(test.to, inventory)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := Query(policies, source, destination, test.port)
+			if result.Allowed != test.allowed || result.Egress.Allowed != test.egress || result.Ingress.Allowed != test.ingress {
+				t.Errorf("got allowed %v, egress %v, ingress %v, want %v, %v, %v",
+					result.Allowed, result.Egress.Allowed, result.Ingress.Allowed, test.allowed, test.egress, test.ingress)
+			}
+			var rules []string
+			for _, rule := range result.Ingress.Rules {
+				rules = append(rules, rule.Action)
+			}
+			if !reflect.DeepEqual(rules, test.rules) {
+				t.Errorf("got ingress rules %v, want %v", rules, test.rules)
+			}
+		})
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", "v.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value     string
+		namespace string
+		name      string
+		wantErr   bool
+	}{
+		{value: "10.128.1.7", namespace: "shop", name: "web-0"},
+		{value: "fd00::7", namespace: "shop", name: "web-0"},
+		{value: "192.0.2.1", name: "192.0.2.1"},
+		{value: "db/pg-0", namespace: "db", name: "pg-0"},
+		{value: "edge:app=lb", namespace: "edge", name: "edge:app=lb"},
+		{value: "api.example.com", name: "api.example.com"},
+		{value: "db/missing", wantErr: true},
+		{value: "not an endpoint", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			endpoint, err := ParseEndpoint(test.value, &manifests.Inventory)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", endpoint)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if endpoint.Namespace != test.namespace || endpoint.Name != test.name {
+				t.Errorf("got %s/%s, want %s/%s", endpoint.Namespace, endpoint.Name, test.namespace, test.name)
+			}
+		})
+	}
+}
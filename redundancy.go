@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Checks of the redundancy analysis
+const (
+	CheckRedundantRule     = "redundant-rule"
+	CheckIneffectiveExcept = "ineffective-except"
+	CheckRedundantPolicy   = "redundant-policy"
+)
+
+// AnalyzeRedundant finds the allow rules covered by another rule, the except blocks another rule allows anyway,
+// and the policies whose every rule is covered by other policies, the ones that can be deleted
+// a rule covers another when it has the same direction and action, the same or broader target and peer and more ports
+func AnalyzeRedundant(policies []FirewallPolicy) []Finding {
+	var findings []Finding
+
+	for _, policy := range policies {
+		for i, rule := range policy.Rules {
+			if egressFirewallKinds[rule.Policy.Kind] || rule.Tier != "" {
+				if earlier, ok := findShadow(firstMatchEarlier(policies, policy, i), rule); ok {
+					findings = append(findings, newFinding(SeverityLow, CheckRedundantRule, rule,
+						fmt.Sprintf("never matches, %s rule %d matches its traffic first", earlier.Policy, earlier.Order)))
+				}
+				continue
+			}
+			switch rule.Action {
+			case ActionAllow:
+				if cover, ok := findCover(policies, rule, true); ok {
+					findings = append(findings, newFinding(SeverityLow, CheckRedundantRule, rule,
+						fmt.Sprintf("covered by %s rule %d", cover.Policy, cover.Order)))
+				}
+			case ActionReject:
+				if allow, ok := findExceptAllow(policies, rule); ok {
+					findings = append(findings, newFinding(SeverityMedium, CheckIneffectiveExcept, rule,
+						fmt.Sprintf("except %s never takes effect, %s rule %d allows it", peerLocation(rule).CIDR, allow.Policy, allow.Order)))
+				}
+			}
+		}
+	}
+
+	// a deleted policy no longer covers the ones after it
+	deleted := map[int]bool{}
+	for i, policy := range policies {
+		if policy.Name == mergedRulebaseName || (policy.Kind != "" && policy.Kind != KindMultiNetworkPolicy) || len(policy.Rules) == 0 {
+			continue
+		}
+		redundant := true
+		var covers []string
+		for _, rule := range policy.Rules {
+			if rule.Action == ActionReject {
+				continue
+			}
+			cover, ok := findCover(excludePolicies(policies, deleted, i), rule, false)
+			if !ok {
+				redundant = false
+				break
+			}
+			covers = append(covers, cover.Policy.String())
+		}
+		if !redundant {
+			continue
+		}
+		deleted[i] = true
+		findings = append(findings, Finding{
+			Severity: SeverityLow,
+			Check:    CheckRedundantPolicy,
+			Policy:   policy.Reference().String(),
+			Order:    -1,
+			Reason:   fmt.Sprintf("every rule is covered by %s, the policy can be deleted", strings.Join(uniqueSorted(covers), ", ")),
+		})
+	}
+
+	sortFindings(findings)
+	return findings
+}
+
+// excludePolicies returns the policies without the deleted ones and the given one
+func excludePolicies(policies []FirewallPolicy, deleted map[int]bool, current int) []FirewallPolicy {
+	var kept []FirewallPolicy
+	for i, policy := range policies {
+		if i != current && !deleted[i] {
+			kept = append(kept, policy)
+		}
+	}
+	return kept
+}
+
+// findCover returns a rule of the policies covering the given one
+// with keepFirst, among rules covering each other only the first one is a cover
+func findCover(policies []FirewallPolicy, rule FirewallRule, keepFirst bool) (FirewallRule, bool) {
+	for _, policy := range policies {
+		for _, other := range policy.Rules {
+			if other.Policy == rule.Policy && other.Order == rule.Order {
+				continue
+			}
+			// egress firewalls and admin tiers apply around network policies, their rules never cover network policy rules
+			// and the rules of a MultiNetworkPolicy only cover the ones of its network
+			if other.Policy.Kind != rule.Policy.Kind {
+				continue
+			}
+			if !ruleCovers(other, rule) {
+				continue
+			}
+			if keepFirst && ruleCovers(rule, other) && other.Order > rule.Order {
+				continue
+			}
+			return other, true
+		}
+	}
+	return FirewallRule{}, false
+}
+
+// firstMatchEarlier returns the rules matching before a rule of a first match rulebase, the earlier rules of
+// its egress firewall, or the rules of its tier before it
+func firstMatchEarlier(policies []FirewallPolicy, policy FirewallPolicy, index int) []FirewallRule {
+	rule := policy.Rules[index]
+	if rule.Tier == "" {
+		return policy.Rules[:index]
+	}
+	var earlier []FirewallRule
+	for _, other := range policies {
+		for _, candidate := range other.Rules {
+			if candidate.Tier == rule.Tier && tierLess(candidate, rule) {
+				earlier = append(earlier, candidate)
+			}
+		}
+	}
+	sort.SliceStable(earlier, func(i, j int) bool {
+		return tierLess(earlier[i], earlier[j])
+	})
+	return earlier
+}
+
+// findShadow returns an earlier rule of a first match rulebase matching all the traffic of a rule, whatever its action
+func findShadow(earlier []FirewallRule, rule FirewallRule) (FirewallRule, bool) {
+	for _, other := range earlier {
+		// compared as allow rules so the ports count
+		shadow, shadowed := other, rule
+		shadow.Action, shadowed.Action = ActionAllow, ActionAllow
+		if ruleCovers(shadow, shadowed) {
+			return other, true
+		}
+	}
+	return FirewallRule{}, false
+}
+
+// ruleCovers reports if rule a matches all the traffic rule b matches
+func ruleCovers(a FirewallRule, b FirewallRule) bool {
+	if a.Direction != b.Direction || a.Action != b.Action {
+		return false
+	}
+	if !locationCovers(targetLocation(a), targetLocation(b)) || !locationCovers(peerLocation(a), peerLocation(b)) {
+		return false
+	}
+	return a.Action == ActionDeny || portsCover(rulePorts(a), rulePorts(b))
+}
+
+// findExceptAllow returns an allow rule of the same direction letting the except block of a reject rule through
+func findExceptAllow(policies []FirewallPolicy, reject FirewallRule) (FirewallRule, bool) {
+	except := FirewallLocation{CIDR: peerLocation(reject).CIDR}
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			if rule.Action != ActionAllow || rule.Direction != reject.Direction || rule.Policy.Kind != reject.Policy.Kind {
+				continue
+			}
+			if locationCovers(targetLocation(rule), targetLocation(reject)) &&
+				locationCovers(peerLocation(rule), except) &&
+				portsCover(rulePorts(rule), rulePorts(reject)) {
+				return rule, true
+			}
+		}
+	}
+	return FirewallRule{}, false
+}
+
+// targetLocation returns the location of the pods a rule applies to
+func targetLocation(rule FirewallRule) FirewallLocation {
+	if rule.Direction == netv1.PolicyTypeIngress {
+		return rule.To
+	}
+	return rule.From
+}
+
+// peerLocation returns the location of the peer of a rule
+func peerLocation(rule FirewallRule) FirewallLocation {
+	return *rule.PortsLocation()
+}
+
+// portsCover reports if every port of b is in a
+func portsCover(a PortSet, b PortSet) bool {
+	if a.All {
+		return true
+	}
+	if b.All {
+		return false
+	}
+	for key := range b.Ports {
+		if !a.Contains(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// locationCovers reports if location a holds every pod or address of location b
+func locationCovers(a FirewallLocation, b FirewallLocation) bool {
+	switch {
+	case a.Network != b.Network:
+		return false
+	case a.Any:
+		return true
+	case b.Any:
+		return false
+	case a.DNSName != "" || b.DNSName != "":
+		return a.DNSName != "" && dnsNameMatches(a.DNSName, strings.TrimPrefix(b.DNSName, "*"))
+	case a.CIDR != "" || b.CIDR != "":
+		return a.CIDR != "" && b.CIDR != "" && cidrCovers(a, b)
+	}
+	return namespacesCover(a, b) && selectorCovers(a.PodSelector, b.PodSelector)
+}
+
+// namespacesCover reports if the namespaces of selector location a hold the namespaces of b
+func namespacesCover(a FirewallLocation, b FirewallLocation) bool {
+	switch {
+	case a.AllNamespaces:
+		return true
+	case a.NamespaceSelector != nil:
+		if b.NamespaceSelector != nil {
+			return selectorCovers(a.NamespaceSelector, b.NamespaceSelector)
+		}
+		return b.Namespace != "" && selectorMatches(a.NamespaceSelector, labels.Set{namespaceNameLabel: b.Namespace})
+	}
+	return a.Namespace != "" && a.Namespace == b.Namespace
+}
+
+// selectorCovers reports if selector a matches every set of labels selector b matches
+// nil and empty selectors match everything, otherwise a must hold a subset of the requirements of b
+func selectorCovers(a *metav1.LabelSelector, b *metav1.LabelSelector) bool {
+	if a == nil || (len(a.MatchLabels) == 0 && len(a.MatchExpressions) == 0) {
+		return true
+	}
+	if b == nil {
+		return false
+	}
+	for key, value := range a.MatchLabels {
+		if other, ok := b.MatchLabels[key]; !ok || other != value {
+			return false
+		}
+	}
+	for _, expression := range a.MatchExpressions {
+		found := false
+		for _, other := range b.MatchExpressions {
+			if reflect.DeepEqual(expression, other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// cidrCovers reports if the CIDR location a holds every address of the CIDR location b
+// an except block of a overlapping b must be inside an except block of b
+func cidrCovers(a FirewallLocation, b FirewallLocation) bool {
+	if !cidrInside(b.CIDR, a.CIDR) {
+		return false
+	}
+	for _, except := range a.Except {
+		if !cidrOverlaps(except, b.CIDR) {
+			continue
+		}
+		excepted := false
+		for _, other := range b.Except {
+			if cidrInside(except, other) {
+				excepted = true
+				break
+			}
+		}
+		if !excepted {
+			return false
+		}
+	}
+	return true
+}
+
+// cidrInside reports if the CIDR inner is part of the CIDR outer
+func cidrInside(inner string, outer string) bool {
+	_, innerNetwork, err := net.ParseCIDR(inner)
+	if err != nil {
+		return false
+	}
+	_, outerNetwork, err := net.ParseCIDR(outer)
+	if err != nil {
+		return false
+	}
+	innerOnes, innerBits := innerNetwork.Mask.Size()
+	outerOnes, outerBits := outerNetwork.Mask.Size()
+	return innerBits == outerBits && outerOnes <= innerOnes && outerNetwork.Contains(innerNetwork.IP)
+}
+
+// cidrOverlaps reports if two CIDRs share addresses
+func cidrOverlaps(a string, b string) bool {
+	return cidrInside(a, b) || cidrInside(b, a)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestAnalyzeRedundant(t *testing.T) {
+	api := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}
+	http := intstr.FromInt(80)
+	fromAPI := netv1.NetworkPolicyIngressRule{From: []netv1.NetworkPolicyPeer{{PodSelector: api}}, Ports: []netv1.NetworkPolicyPort{{Port: &http}}}
+	fromBlock := func(cidr string, except ...string) netv1.NetworkPolicyIngressRule {
+		return netv1.NetworkPolicyIngressRule{From: []netv1.NetworkPolicyPeer{{IPBlock: &netv1.IPBlock{CIDR: cidr, Except: except}}}, Ports: []netv1.NetworkPolicyPort{{Port: &http}}}
+	}
+	policy := func(name string, ingress ...netv1.NetworkPolicyIngressRule) netv1.NetworkPolicy {
+		return netv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name},
+			Spec: netv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+				Ingress:     ingress,
+			},
+		}
+	}
+	firewall := func(rules ...EgressFirewallRule) []EgressFirewall {
+		return []EgressFirewall{{
+			TypeMeta:   metav1.TypeMeta{Kind: KindEgressFirewall},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "default"},
+			Spec:       EgressFirewallSpec{Egress: rules},
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		policies  []netv1.NetworkPolicy
+		firewalls []EgressFirewall
+		want      []string
+	}{
+		{
+			name:     "rule covered by a rule on more ports",
+			policies: []netv1.NetworkPolicy{policy("a", fromAPI), policy("b", netv1.NetworkPolicyIngressRule{From: fromAPI.From})},
+			want:     []string{"low redundant-policy ns/a -1", "low redundant-rule ns/a 0"},
+		},
+		{
+			name:     "same rule twice, only the later one",
+			policies: []netv1.NetworkPolicy{policy("a", fromAPI, fromAPI)},
+			want:     []string{"low redundant-rule ns/a 1"},
+		},
+		{
+			name:     "same policy twice, only one can be deleted",
+			policies: []netv1.NetworkPolicy{policy("a", fromAPI), policy("b", fromAPI)},
+			want:     []string{"low redundant-policy ns/a -1", "low redundant-rule ns/b 2"},
+		},
+		{
+			name:     "rules of other peers",
+			policies: []netv1.NetworkPolicy{policy("a", fromAPI), policy("b", fromBlock("10.0.0.0/8"))},
+		},
+		{
+			name:     "except block another policy allows",
+			policies: []netv1.NetworkPolicy{policy("a", fromBlock("10.0.0.0/8", "10.1.0.0/16")), policy("b", fromBlock("10.1.0.0/16"))},
+			want:     []string{"medium ineffective-except ns/a 0"},
+		},
+		{
+			name:     "except block another policy only partly allows",
+			policies: []netv1.NetworkPolicy{policy("a", fromBlock("10.0.0.0/8", "10.1.0.0/16")), policy("b", fromBlock("10.1.2.0/24"))},
+		},
+		{
+			name:     "block inside an allowed block, outside its except block",
+			policies: []netv1.NetworkPolicy{policy("a", fromBlock("10.0.0.0/8", "10.1.0.0/16")), policy("b", fromBlock("10.2.0.0/16"))},
+			want:     []string{"low redundant-policy ns/b -1", "low redundant-rule ns/b 3"},
+		},
+		{
+			name: "egress firewall rule after a rule matching its traffic",
+			firewalls: firewall(
+				EgressFirewallRule{Type: "Deny", To: EgressFirewallDestination{CIDRSelector: "0.0.0.0/0"}},
+				EgressFirewallRule{Type: "Allow", To: EgressFirewallDestination{CIDRSelector: "1.2.3.0/24"}},
+			),
+			want: []string{"low redundant-rule ns/EgressFirewall/default 1"},
+		},
+		{
+			name: "egress firewall rule before a broader one",
+			firewalls: firewall(
+				EgressFirewallRule{Type: "Allow", To: EgressFirewallDestination{CIDRSelector: "1.2.3.0/24"}},
+				EgressFirewallRule{Type: "Deny", To: EgressFirewallDestination{CIDRSelector: "0.0.0.0/0"}},
+			),
+		},
+		{
+			name: "egress firewall rule on ports after a rule on all ports",
+			firewalls: firewall(
+				EgressFirewallRule{Type: "Allow", To: EgressFirewallDestination{CIDRSelector: "1.2.3.0/24"}},
+				EgressFirewallRule{Type: "Deny", To: EgressFirewallDestination{CIDRSelector: "1.2.3.4/32"}, Ports: []EgressFirewallPort{{Protocol: "TCP", Port: 22}}},
+			),
+			want: []string{"low redundant-rule ns/EgressFirewall/default 1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			translator := NewTranslator(0)
+			translator.TranslatePolicies(test.policies)
+			policies := translator.TranslateEgressFirewalls(test.firewalls)
+
+			var got []string
+			for _, finding := range AnalyzeRedundant(policies) {
+				got = append(got, findingLine(finding))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got findings\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportData is what the HTML report template renders
+type reportData struct {
+	Generated  string
+	Summary    []reportFigure
+	Namespaces []reportNamespace
+	Columns    []string
+	Matrix     []reportMatrixRow
+	Exposed    []UnprotectedPod
+}
+
+// reportFigure is a figure of the summary
+type reportFigure struct {
+	Name  string
+	Value int
+}
+
+// reportNamespace holds the rules of the policies of a namespace
+type reportNamespace struct {
+	Name  string
+	Rules []FlatRule
+}
+
+// reportMatrixRow is the connectivity from a namespace to every namespace of the matrix columns
+type reportMatrixRow struct {
+	From  string
+	Cells []MatrixCell
+}
+
+// htmlExporter writes a self-contained HTML report of the policies, the connectivity between namespaces
+// and the pods left unprotected outside the namespaces matching exclude, with no external assets so it can be read anywhere
+type htmlExporter struct {
+	inventory *Inventory
+	exclude   *regexp.Regexp
+}
+
+func (e htmlExporter) Export(w io.Writer, policies []FirewallPolicy) error {
+	data := reportData{Generated: time.Now().UTC().Format(time.RFC3339)}
+
+	index := map[string]*reportNamespace{}
+	rules := FlattenRules(policies)
+	for _, rule := range rules {
+		if index[rule.Namespace] == nil {
+			index[rule.Namespace] = &reportNamespace{Name: rule.Namespace}
+		}
+		index[rule.Namespace].Rules = append(index[rule.Namespace].Rules, rule)
+	}
+	for _, namespace := range index {
+		data.Namespaces = append(data.Namespaces, *namespace)
+	}
+	sort.Slice(data.Namespaces, func(i, j int) bool {
+		return data.Namespaces[i].Name < data.Namespaces[j].Name
+	})
+
+	allowed := 0
+	cells := ConnectivityMatrix(policies, e.inventory, MatrixNamespace)
+	rows := map[string]*reportMatrixRow{}
+	var order []string
+	for _, cell := range cells {
+		if rows[cell.From] == nil {
+			rows[cell.From] = &reportMatrixRow{From: cell.From}
+			order = append(order, cell.From)
+		}
+		rows[cell.From].Cells = append(rows[cell.From].Cells, cell)
+		if cell.Allowed {
+			allowed++
+		}
+	}
+	data.Columns = order
+	for _, name := range order {
+		data.Matrix = append(data.Matrix, *rows[name])
+	}
+
+	pods := 0
+	if e.inventory != nil {
+		pods = len(e.inventory.NetworkPods())
+		for _, namespace := range AuditUnprotected(policies, e.inventory, e.exclude) {
+			data.Exposed = append(data.Exposed, namespace.Pods...)
+		}
+	}
+
+	policyCount := 0
+	for _, policy := range policies {
+		if policy.Name != mergedRulebaseName {
+			policyCount++
+		}
+	}
+	data.Summary = []reportFigure{
+		{"Policies", policyCount},
+		{"Rules", len(rules)},
+		{"Namespaces with policies", len(data.Namespaces)},
+		{"Pods", pods},
+		{"Pods without ingress or egress policy", len(data.Exposed)},
+		{"Allowed namespace pairs", allowed},
+		{"Namespace pairs", len(cells)},
+	}
+
+	return reportTemplate.Execute(w, data)
+}
+
+// reportTemplate is the HTML report, styles and scripts are inlined
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Network policy report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1, h2, h3 { font-weight: normal; }
+table { border-collapse: collapse; margin-bottom: 1.5em; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+td.allowed { background: #d9ead3; }
+td.denied { background: #f4cccc; }
+tr.REJECT td { color: #cc0000; }
+tr.DENY td { color: #666; }
+#search { width: 30em; padding: 0.4em; margin-bottom: 1em; }
+.hidden { display: none; }
+</style>
+</head>
+<body>
+<h1>Network policy report</h1>
+<p>Generated {{.Generated}}</p>
+
+<h2>Summary</h2>
+<table>
+{{- range .Summary}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+
+<input id="search" type="search" placeholder="Filter rules, cells and pods" oninput="filter(this.value)">
+
+<h2>Rules</h2>
+{{- range .Namespaces}}
+<div class="namespace">
+<h3>{{.Name}}</h3>
+<table class="filterable">
+<tr><th>Policy</th><th>Order</th><th>Direction</th><th>Action</th><th>From</th><th>To</th><th>Ports</th><th>From workloads</th><th>To workloads</th></tr>
+{{- range .Rules}}
+<tr class="{{.Action}}"><td>{{.Policy}}</td><td>{{.Order}}</td><td>{{.Direction}}</td><td>{{.Action}}</td><td>{{.From}}</td><td>{{.To}}</td><td>{{.Ports}}</td><td>{{.FromWorkloads}}</td><td>{{.ToWorkloads}}</td></tr>
+{{- end}}
+</table>
+</div>
+{{- else}}
+<p>No policies</p>
+{{- end}}
+
+<h2>Connectivity between namespaces</h2>
+<table class="filterable">
+<tr><th>From \ To</th>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Matrix}}
+<tr><th>{{.From}}</th>{{range .Cells}}{{if .Allowed}}<td class="allowed" title="{{.From}} to {{.To}}">{{join .Ports " "}}{{if .Partial}} (partial){{end}}</td>{{else}}<td class="denied" title="{{.From}} to {{.To}}">none</td>{{end}}{{end}}</tr>
+{{- end}}
+</table>
+
+<h2>Unprotected pods</h2>
+{{- if .Exposed}}
+<table class="filterable">
+<tr><th>Namespace</th><th>Pod</th><th>Workload</th><th>Unprotected</th></tr>
+{{- range .Exposed}}
+<tr><td>{{.Namespace}}</td><td>{{.Pod}}</td><td>{{.Workload}}</td><td>{{.Unprotected}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>Every pod is isolated for ingress and egress</p>
+{{- end}}
+
+<script>
+function filter(text) {
+	text = text.toLowerCase();
+	document.querySelectorAll("table.filterable").forEach(function (table) {
+		table.querySelectorAll("tr").forEach(function (row, i) {
+			if (i > 0) {
+				row.classList.toggle("hidden", text !== "" && row.textContent.toLowerCase().indexOf(text) < 0);
+			}
+		});
+	});
+	document.querySelectorAll("div.namespace").forEach(function (section) {
+		section.classList.toggle("hidden", section.querySelectorAll("tr:not(.hidden)").length < 2);
+	});
+}
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sort"
+)
+
+// mergedRulebaseName is the name of the single policy holding a merged rulebase
+const mergedRulebaseName = "cluster-rulebase"
+
+// actionPrecedence orders the actions of a merged rulebase, rejects of except CIDRs come first and implicit denies last
+var actionPrecedence = map[string]int{
+	ActionReject: 0,
+	ActionAllow:  1,
+	ActionDeny:   2,
+}
+
+// Positions of the rules of each tier in a merged rulebase
+const (
+	mergedTierEgressFirewall = iota
+	mergedTierAdmin
+	mergedTierNetworkPolicy
+	mergedTierBaseline
+)
+
+// mergedTier returns the position of the tier of a rule in a merged rulebase
+func mergedTier(rule FirewallRule) int {
+	switch {
+	case egressFirewallKinds[rule.Policy.Kind]:
+		return mergedTierEgressFirewall
+	case rule.Tier == TierAdmin:
+		return mergedTierAdmin
+	case rule.Tier == TierBaseline:
+		return mergedTierBaseline
+	}
+	return mergedTierNetworkPolicy
+}
+
+// MergeRulebase merges the rules of all policies into a single rulebase ordered like a zoned firewall
+// rules keep their translation order inside each action and are renumbered with their global position
+// egress firewall rules come first in their own order, traffic they allow goes on to the next rules
+// then come the admin tier rules by priority, passing traffic on to the network policy rules,
+// and last the baseline tier rules for the pods no network policy isolates
+func MergeRulebase(policies []FirewallPolicy) []FirewallRule {
+	rules := mergedRules(policies)
+	for i := range rules {
+		rules[i].Order = i
+	}
+	return rules
+}
+
+// mergedRules returns the rules of all policies in merged rulebase order, they keep the order of their policies
+func mergedRules(policies []FirewallPolicy) []FirewallRule {
+	var rules []FirewallRule
+	for _, policy := range policies {
+		rules = append(rules, policy.Rules...)
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if mergedTier(rules[i]) != mergedTier(rules[j]) {
+			return mergedTier(rules[i]) < mergedTier(rules[j])
+		}
+		if mergedTier(rules[i]) != mergedTierNetworkPolicy {
+			return tierLess(rules[i], rules[j])
+		}
+		if actionPrecedence[rules[i].Action] != actionPrecedence[rules[j].Action] {
+			return actionPrecedence[rules[i].Action] < actionPrecedence[rules[j].Action]
+		}
+		return rules[i].Order < rules[j].Order
+	})
+	return rules
+}
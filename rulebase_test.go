@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeRulebase(t *testing.T) {
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", "v.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := TranslatePolicies(manifests.Policies)
+
+	// the translation numbers the rules across policies and every rule references its policy
+	next := 0
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			if rule.Order != next {
+				t.Errorf("rule %s has order %d, want %d", ruleLine(rule), rule.Order, next)
+			}
+			if rule.Policy != policy.Reference() {
+				t.Errorf("rule %d references %s, want %s", rule.Order, rule.Policy, policy.Reference())
+			}
+			next++
+		}
+	}
+
+	// the except rejects come first, then the allows and the implicit denies, each in translation order
+	want := []string{
+		"shop/web 1 REJECT",
+		"shop/web 0 ALLOW",
+		"shop/web 2 ALLOW",
+		"db/pg 4 ALLOW",
+		"db/pg 6 ALLOW",
+		"shop/web 3 DENY",
+		"db/pg 5 DENY",
+		"db/pg 7 DENY",
+	}
+	var got []string
+	for _, rule := range mergedRules(policies) {
+		got = append(got, fmt.Sprintf("%s %d %s", rule.Policy, rule.Order, rule.Action))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got merged rulebase\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// the merged rulebase is numbered by position
+	for i, rule := range MergeRulebase(policies) {
+		if rule.Order != i {
+			t.Errorf("merged rule %d has order %d", i, rule.Order)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format, bumped on incompatible changes
+const snapshotVersion = 1
+
+// Snapshot is the translated policies of a cluster at a point in time, with the inventory they were resolved against
+type Snapshot struct {
+	Version   int              `json:"version"`
+	Created   time.Time        `json:"created"`
+	Policies  []FirewallPolicy `json:"policies"`
+	Inventory *Inventory       `json:"inventory,omitempty"`
+}
+
+// SaveSnapshot writes the policies and their inventory to a snapshot file
+func SaveSnapshot(path string, policies []FirewallPolicy, inventory *Inventory) error {
+	snapshot := Snapshot{
+		Version:   snapshotVersion,
+		Created:   time.Now().UTC(),
+		Policies:  policies,
+		Inventory: inventory,
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadSnapshot reads a snapshot file
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := new(Snapshot)
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("%s: unsupported snapshot version %d, expected %d", path, snapshot.Version, snapshotVersion)
+	}
+	if snapshot.Inventory == nil {
+		snapshot.Inventory = new(Inventory)
+	}
+	return snapshot, nil
+}
+
+// Changes of a rule between two sets of policies
+const (
+	RuleAdded   = "added"
+	RuleRemoved = "removed"
+	RuleChanged = "changed"
+)
+
+// RuleChange is a rule added, removed or with other ports or workloads between two sets of policies
+// rules are matched on their policy, direction, action, source and destination, orders are not compared
+type RuleChange struct {
+	Change       string `json:"change" header:"Change"`
+	Policy       string `json:"policy" header:"Policy"`
+	Direction    string `json:"direction" header:"Direction"`
+	Action       string `json:"action" header:"Action"`
+	From         string `json:"from" header:"From"`
+	To           string `json:"to" header:"To"`
+	Ports        string `json:"ports,omitempty" header:"Ports"`
+	OldPorts     string `json:"oldPorts,omitempty" header:"Old Ports"`
+	Workloads    string `json:"workloads,omitempty" header:"Workloads"`
+	OldWorkloads string `json:"oldWorkloads,omitempty" header:"Old Workloads"`
+	ruleIdentity string
+}
+
+// ConnectivityChange is the traffic gained and lost from a namespace to another
+type ConnectivityChange struct {
+	From   string   `json:"from" header:"From"`
+	To     string   `json:"to" header:"To"`
+	Gained []string `json:"gained,omitempty" header:"Gained"`
+	Lost   []string `json:"lost,omitempty" header:"Lost"`
+}
+
+// PolicyDiff is the difference between two sets of policies, in rules and in connectivity between namespaces
+type PolicyDiff struct {
+	Rules        []RuleChange         `json:"rules"`
+	Connectivity []ConnectivityChange `json:"connectivity"`
+}
+
+// DiffPolicies compares the old policies and inventory with the new ones
+func DiffPolicies(oldPolicies []FirewallPolicy, oldInventory *Inventory, newPolicies []FirewallPolicy, newInventory *Inventory) PolicyDiff {
+	diff := PolicyDiff{Rules: []RuleChange{}, Connectivity: []ConnectivityChange{}}
+
+	oldRules, newRules := diffRules(oldPolicies), diffRules(newPolicies)
+	for key, rule := range newRules {
+		old, ok := oldRules[key]
+		switch {
+		case !ok:
+			rule.Change = RuleAdded
+			diff.Rules = append(diff.Rules, rule)
+		case old.Ports != rule.Ports || old.Workloads != rule.Workloads:
+			rule.Change = RuleChanged
+			if old.Ports != rule.Ports {
+				rule.OldPorts = old.Ports
+			}
+			if old.Workloads != rule.Workloads {
+				rule.OldWorkloads = old.Workloads
+			}
+			diff.Rules = append(diff.Rules, rule)
+		}
+	}
+	for key, rule := range oldRules {
+		if _, ok := newRules[key]; !ok {
+			rule.Change = RuleRemoved
+			diff.Rules = append(diff.Rules, rule)
+		}
+	}
+	sort.Slice(diff.Rules, func(i, j int) bool {
+		return diff.Rules[i].ruleIdentity < diff.Rules[j].ruleIdentity
+	})
+
+	diff.Connectivity = diffConnectivity(
+		ConnectivityMatrix(oldPolicies, oldInventory, MatrixNamespace),
+		ConnectivityMatrix(newPolicies, newInventory, MatrixNamespace))
+	return diff
+}
+
+// diffRules indexes the rules of policies by identity, the ports and workloads of rules sharing an identity are merged
+func diffRules(policies []FirewallPolicy) map[string]RuleChange {
+	rules := map[string]RuleChange{}
+	for _, row := range FlattenRules(policies) {
+		policy := row.Namespace + "/" + row.Policy
+		key := strings.Join([]string{policy, row.Network, row.Direction, row.Action, row.From, row.To}, "\x00")
+		rule, ok := rules[key]
+		if !ok {
+			rule = RuleChange{Policy: policy, Direction: row.Direction, Action: row.Action, From: row.From, To: row.To, ruleIdentity: key}
+		}
+		rule.Ports = mergeFields(rule.Ports, row.Ports, ",")
+		workloads := row.ToWorkloads
+		if row.Direction == "Egress" {
+			workloads = row.FromWorkloads
+		}
+		rule.Workloads = mergeFields(rule.Workloads, workloads, " ")
+		rules[key] = rule
+	}
+	return rules
+}
+
+// mergeFields merges two separated lists into a sorted list of distinct values
+func mergeFields(a string, b string, separator string) string {
+	return strings.Join(uniqueSorted(append(strings.Split(a, separator), strings.Split(b, separator)...)), separator)
+}
+
+// diffConnectivity compares two namespace matrices, a namespace missing on one side has no connectivity there
+func diffConnectivity(oldCells []MatrixCell, newCells []MatrixCell) []ConnectivityChange {
+	ports := func(cells []MatrixCell) map[string]PortSet {
+		index := map[string]PortSet{}
+		for _, cell := range cells {
+			set := NewPortSet()
+			if cell.Allowed {
+				set = NewPortSet(cell.Ports...)
+				if len(cell.Ports) == 1 && strings.HasPrefix(cell.Ports[0], "ANY") {
+					set = allPortsExcept()
+					if except := strings.TrimPrefix(cell.Ports[0], "ANY except "); except != cell.Ports[0] {
+						set = allPortsExcept(strings.Split(except, ",")...)
+					}
+				}
+			}
+			index[cell.From+"\x00"+cell.To] = set
+		}
+		return index
+	}
+	oldPorts, newPorts := ports(oldCells), ports(newCells)
+
+	var keys []string
+	for key := range oldPorts {
+		keys = append(keys, key)
+	}
+	for key := range newPorts {
+		if _, ok := oldPorts[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []ConnectivityChange{}
+	for _, key := range keys {
+		before, ok := oldPorts[key]
+		if !ok {
+			before = NewPortSet()
+		}
+		after, ok := newPorts[key]
+		if !ok {
+			after = NewPortSet()
+		}
+		names := strings.SplitN(key, "\x00", 2)
+		change := ConnectivityChange{From: names[0], To: names[1], Gained: portsDifference(after, before), Lost: portsDifference(before, after)}
+		if len(change.Gained) > 0 || len(change.Lost) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// portsDifference returns the port keys of a not in b
+func portsDifference(a PortSet, b PortSet) []string {
+	return a.Subtract(b).Keys()
+}
+
+// PrintDiff writes a diff as JSON or as two tables
+func PrintDiff(w io.Writer, diff PolicyDiff, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(diff)
+	case "table":
+		fmt.Fprintln(w, "Rules:")
+		newTablePrinter(w).Print(diff.Rules)
+		fmt.Fprintln(w, "Connectivity between namespaces:")
+		newTablePrinter(w).Print(diff.Connectivity)
+		return nil
+	}
+	return fmt.Errorf("unknown diff format %q, use json or table", format)
+}
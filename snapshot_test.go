@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// loadFixture translates the policies of a fixture and resolves them against its inventory
+func loadFixture(t *testing.T, fixture string, change func(*Manifests)) ([]FirewallPolicy, *Inventory) {
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", fixture)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change != nil {
+		change(manifests)
+	}
+	policies := TranslatePolicies(manifests.Policies)
+	ResolveWorkloads(policies, &manifests.Inventory)
+	ResolveNamedPorts(policies, &manifests.Inventory)
+	return policies, &manifests.Inventory
+}
+
+func TestSnapshot(t *testing.T) {
+	policies, inventory := loadFixture(t, "v.yaml", nil)
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshot.json")
+	if err := SaveSnapshot(path, policies, inventory); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := DiffPolicies(snapshot.Policies, snapshot.Inventory, policies, inventory); len(diff.Rules) > 0 || len(diff.Connectivity) > 0 {
+		t.Errorf("snapshot differs from the policies it was saved from: %+v", diff)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"version": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSnapshot(path); err == nil || !strings.Contains(err.Error(), "unsupported snapshot version 2") {
+		t.Errorf("got error %v, want an unsupported version", err)
+	}
+}
+
+func TestDiffPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Manifests)
+		// rules are change, policy, direction, action, from, to, ports and workloads of the changed rules
+		rules []string
+		// connectivity are the namespaces and the ports gained and lost between them
+		connectivity []string
+	}{
+		{
+			name: "no change",
+		},
+		{
+			name: "port added",
+			change: func(m *Manifests) {
+				port := intstr.FromInt(8443)
+				m.Policies[0].Spec.Ingress[0].Ports = append(m.Policies[0].Spec.Ingress[0].Ports, netv1.NetworkPolicyPort{Port: &port})
+				defaultPolicy(&m.Policies[0])
+			},
+			rules: []string{
+				"changed shop/web Ingress ALLOW 10.0.0.0/8 except 10.1.0.0/16 shop/app=web TCP/8080,TCP/8443,TCP/9443 was TCP/8080,TCP/9443",
+				"changed shop/web Ingress ALLOW {team=edge}/app=lb shop/app=web TCP/8080,TCP/8443,TCP/9443 was TCP/8080,TCP/9443",
+				"changed shop/web Ingress REJECT 10.1.0.0/16 shop/app=web TCP/8080,TCP/8443,TCP/9443 was TCP/8080,TCP/9443",
+			},
+			connectivity: []string{"edge shop gained [TCP/8443] lost []", "shop shop gained [TCP/8443] lost []"},
+		},
+		{
+			name: "policy deleted",
+			change: func(m *Manifests) {
+				m.Policies = m.Policies[:1]
+			},
+			rules: []string{
+				"removed db/pg Egress ALLOW db/app=pg 10.96.0.0/12 SCTP,UDP/53",
+				"removed db/pg Egress DENY db/app=pg any ANY",
+				"removed db/pg Ingress ALLOW */app=web db/app=pg TCP/5432",
+				"removed db/pg Ingress DENY any db/app=pg ANY",
+			},
+			connectivity: []string{
+				"db db gained [ANY] lost []",
+				"db edge gained [ANY] lost []",
+				"db shop gained [TCP/8080 TCP/9443] lost []",
+				"edge db gained [ANY] lost []",
+				"shop db gained [ANY except TCP/5432] lost []",
+			},
+		},
+		{
+			name: "pod added",
+			change: func(m *Manifests) {
+				pod := *m.Inventory.Pods[1].DeepCopy()
+				pod.Name = "web-1"
+				m.Inventory.Pods = append(m.Inventory.Pods, pod)
+			},
+			rules: []string{
+				"changed shop/web Ingress ALLOW 10.0.0.0/8 except 10.1.0.0/16 shop/app=web TCP/8080,TCP/9443 workloads shop/web-0 shop/web-1 was shop/web-0",
+				"changed shop/web Ingress ALLOW {team=edge}/app=lb shop/app=web TCP/8080,TCP/9443 workloads shop/web-0 shop/web-1 was shop/web-0",
+				"changed shop/web Ingress DENY any shop/app=web ANY workloads shop/web-0 shop/web-1 was shop/web-0",
+				"changed shop/web Ingress REJECT 10.1.0.0/16 shop/app=web TCP/8080,TCP/9443 workloads shop/web-0 shop/web-1 was shop/web-0",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldPolicies, oldInventory := loadFixture(t, "v.yaml", nil)
+			newPolicies, newInventory := loadFixture(t, "v.yaml", test.change)
+			diff := DiffPolicies(oldPolicies, oldInventory, newPolicies, newInventory)
+
+			var rules []string
+			for _, rule := range diff.Rules {
+				line := fmt.Sprintf("%s %s %s %s %s %s %s", rule.Change, rule.Policy, rule.Direction, rule.Action, rule.From, rule.To, rule.Ports)
+				if rule.OldPorts != "" {
+					line += " was " + rule.OldPorts
+				}
+				if rule.OldWorkloads != "" {
+					line += " workloads " + rule.Workloads + " was " + rule.OldWorkloads
+				}
+				rules = append(rules, line)
+			}
+			if !reflect.DeepEqual(rules, test.rules) {
+				t.Errorf("got rules\n%s\nwant\n%s", strings.Join(rules, "\n"), strings.Join(test.rules, "\n"))
+			}
+
+			var connectivity []string
+			for _, change := range diff.Connectivity {
+				connectivity = append(connectivity, fmt.Sprintf("%s %s gained %v lost %v", change.From, change.To, change.Gained, change.Lost))
+			}
+			if !reflect.DeepEqual(connectivity, test.connectivity) {
+				t.Errorf("got connectivity\n%s\nwant\n%s", strings.Join(connectivity, "\n"), strings.Join(test.connectivity, "\n"))
+			}
+		})
+	}
+}
@@ -5,25 +5,117 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-//FirewallPolicy define list of firewall rules coming from a single network policy
+// Actions of a FirewallRule
+const (
+	// ActionAllow allows the traffic matching the rule
+	ActionAllow = "ALLOW"
+	// ActionReject rejects the traffic excluded from an allowed CIDR
+	ActionReject = "REJECT"
+	// ActionDeny denies the traffic not allowed to isolated pods, or the traffic matching an admin tier rule
+	ActionDeny = "DENY"
+	// ActionPass leaves the traffic matching an admin tier rule to the network policies
+	ActionPass = "PASS"
+)
+
+// Tiers of a FirewallRule, the rules of the admin tier apply before the network policies and the rules of the
+// baseline tier to the pods no network policy isolates, network policy rules have no tier
+const (
+	TierAdmin    = "admin"
+	TierBaseline = "baseline"
+)
+
+// FirewallPolicy define list of firewall rules coming from a single network policy
+// Kind is empty for a NetworkPolicy and names the kind of other policies
+// Network is the namespace/name of the secondary network of a MultiNetworkPolicy, empty for the cluster network
 type FirewallPolicy struct {
 	Name      string
 	Namespace string
+	Kind      string `json:",omitempty"`
+	Network   string `json:",omitempty"`
 	Rules     []FirewallRule
 }
 
-//FirewallRule defines a single rule with from, to and action
+// Reference returns the reference of the policy its rules carry
+func (p FirewallPolicy) Reference() PolicyReference {
+	return PolicyReference{Namespace: p.Namespace, Name: p.Name, Kind: p.Kind}
+}
+
+// FirewallRule defines a single rule with from, to and action
+// rules of a Tier match in Priority order, the lowest first, then in Order
 type FirewallRule struct {
-	From   FirewallLocation `header:"inline"`
-	To     FirewallLocation `header:"To"`
-	Action string           `header:"Action"`
-	Order  int              `header:"Order"`
+	From      FirewallLocation `header:"inline"`
+	To        FirewallLocation `header:"To"`
+	Action    string           `header:"Action"`
+	Order     int              `header:"Order"`
+	Direction netv1.PolicyType `json:"direction" header:"Direction"`
+	Policy    PolicyReference  `json:"policy" header:"Policy"`
+	Tier      string           `json:"tier,omitempty" header:"Tier"`
+	Priority  int              `json:"priority,omitempty" header:"Priority"`
+}
+
+// PolicyReference identifies the network policy a FirewallRule comes from, Kind is empty for a NetworkPolicy
+type PolicyReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind,omitempty"`
+}
+
+// String returns namespace/name of a NetworkPolicy, namespace/kind/name of other policies
+// and kind/name of cluster scoped policies
+func (p PolicyReference) String() string {
+	switch {
+	case p.Kind == "":
+		return p.Namespace + "/" + p.Name
+	case p.Namespace == "":
+		return p.Kind + "/" + p.Name
+	}
+	return p.Namespace + "/" + p.Kind + "/" + p.Name
 }
 
-//FirewallLocation degines a location which can be either podselector, namespaceselector or CIDR
+// FirewallLocation defines a location which can be either podselector, namespaceselector, both, CIDR or any
+// when both selectors are set the location is the pods matching PodSelector in the namespaces matching NamespaceSelector
+// an allowed CIDR keeps the Except blocks rejected before it
+// pods are scoped to a single Namespace, to the namespaces matching NamespaceSelector or to AllNamespaces
+// AllPorts is set when the location is not restricted to Ports and PortRanges, the protocol/start-end ranges
+// of admin policies too large to expand into Ports
+// Workloads are only filled when selectors are resolved against the pods of the cluster, together with
+// the named Ports translated to numbers and the UnresolvedPorts names no target pod defines
+// Zones are the firewall zones of the location, only filled when zones are assigned
+// DNSName is an external destination given by name, a leading *. matches every subdomain
+// Network is the secondary network the pods and addresses are on, empty for the cluster network
 type FirewallLocation struct {
-	PodSelector       metav1.LabelSelector      `json:"podSelector,omitempty" header:"PodSelector"`
-	NamespaceSelector metav1.LabelSelector      `json:"namespaceSelector,omitempty" header:"NamespaceSelector"`
+	Namespace         string                    `json:"namespace,omitempty" header:"Namespace"`
+	AllNamespaces     bool                      `json:"allNamespaces,omitempty" header:"AllNamespaces"`
+	PodSelector       *metav1.LabelSelector     `json:"podSelector,omitempty" header:"PodSelector"`
+	NamespaceSelector *metav1.LabelSelector     `json:"namespaceSelector,omitempty" header:"NamespaceSelector"`
 	CIDR              string                    `json:"CIDR,omitempty" header:"CIDR"`
+	Except            []string                  `json:"except,omitempty" header:"Except"`
+	Any               bool                      `json:"any,omitempty" header:"Any"`
+	DNSName           string                    `json:"dnsName,omitempty" header:"DNSName"`
+	Network           string                    `json:"network,omitempty" header:"Network"`
 	Ports             []netv1.NetworkPolicyPort `json:"ports,omitempty" header:"Ports"`
+	PortRanges        []string                  `json:"portRanges,omitempty" header:"PortRanges"`
+	AllPorts          bool                      `json:"allPorts,omitempty" header:"AllPorts"`
+	UnresolvedPorts   []string                  `json:"unresolvedPorts,omitempty" header:"UnresolvedPorts"`
+	Workloads         []Workload                `json:"workloads,omitempty" header:"Workloads,count"`
+	Zones             []string                  `json:"zones,omitempty" header:"Zones"`
+}
+
+// Workload is a pod resolved from the selectors of a FirewallLocation with the workload owning it
+// Template is set when the pod is built from the template of a workload without running pods
+type Workload struct {
+	Namespace string   `json:"namespace"`
+	Pod       string   `json:"pod"`
+	IPs       []string `json:"ips,omitempty"`
+	OwnerKind string   `json:"ownerKind,omitempty"`
+	OwnerName string   `json:"ownerName,omitempty"`
+	Template  bool     `json:"template,omitempty"`
+}
+
+// Name returns namespace/kind/name of the workload, namespace/pod for pods without owner
+func (w Workload) Name() string {
+	if w.OwnerKind == "" {
+		return w.Namespace + "/" + w.Pod
+	}
+	return w.Namespace + "/" + w.OwnerKind + "/" + w.OwnerName
 }
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// CheckUncoveredSelector flags a selector of the policies no synthetic pod of the round trip check matches
+const CheckUncoveredSelector = "uncovered-selector"
+
+// verifyUnlistedPort is a port no policy is expected to list, it checks what rules restricted to ports leave out
+const verifyUnlistedPort = "65535"
+
+// Counterexample is a connection the NetworkPolicies, their firewall rules and the NetworkPolicies regenerated
+// from the rules do not agree on
+type Counterexample struct {
+	From          string `json:"from" header:"From"`
+	To            string `json:"to" header:"To"`
+	Port          string `json:"port" header:"Port"`
+	NetworkPolicy bool   `json:"networkPolicy" header:"NetworkPolicy"`
+	Firewall      bool   `json:"firewall" header:"Firewall"`
+	Regenerated   bool   `json:"regenerated" header:"Regenerated"`
+}
+
+// Verification is the outcome of a round trip check over every pair of endpoints and every port
+type Verification struct {
+	Endpoints       int              `json:"endpoints"`
+	Ports           int              `json:"ports"`
+	Checked         int              `json:"checked"`
+	Counterexamples []Counterexample `json:"counterexamples"`
+	Unexpressed     []Finding        `json:"unexpressed"`
+	Uncovered       []Finding        `json:"uncovered"`
+}
+
+// VerifyRoundTrip translates the NetworkPolicies to firewall rules, regenerates NetworkPolicies from the rules
+// and checks the three allow the same connections, between the pods of the inventory or synthetic pods standing
+// for the selectors of the policies, the addresses bounding their ipBlocks, on their ports and an unlisted one
+// the NetworkPolicies are evaluated on their own, not through the firewall rules, so the check is independent of the translators
+func VerifyRoundTrip(items []netv1.NetworkPolicy, inventory *Inventory) Verification {
+	firewall := TranslatePolicies(items)
+	// every endpoint carries the name label of its namespace, the regenerated policies select namespaces by it
+	regenerated, unexpressed := ReverseTranslate(firewall, nil)
+
+	endpoints, uncovered := verifyEndpoints(items, inventory)
+	ports := verifyPorts(items)
+	result := Verification{Endpoints: len(endpoints), Ports: len(ports), Counterexamples: []Counterexample{}, Unexpressed: unexpressed, Uncovered: uncovered}
+	if result.Unexpressed == nil {
+		result.Unexpressed = []Finding{}
+	}
+	if result.Uncovered == nil {
+		result.Uncovered = []Finding{}
+	}
+
+	for _, source := range endpoints {
+		for _, destination := range endpoints {
+			if !source.IsPod() && !destination.IsPod() {
+				continue
+			}
+			allowed := EvaluateConnection(firewall, source, destination).Allowed()
+			for _, port := range ports {
+				result.Checked++
+				example := Counterexample{
+					From:          endpointName(source),
+					To:            endpointName(destination),
+					Port:          port,
+					NetworkPolicy: networkPolicyAllows(items, source, destination, port),
+					Firewall:      allowed.Contains(port),
+					Regenerated:   networkPolicyAllows(regenerated, source, destination, port),
+				}
+				if example.NetworkPolicy != example.Firewall || example.NetworkPolicy != example.Regenerated {
+					result.Counterexamples = append(result.Counterexamples, example)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// networkPolicyAllows reports if NetworkPolicies allow a connection on a protocol/port key,
+// the egress side of the source and the ingress side of the destination must both allow it
+func networkPolicyAllows(items []netv1.NetworkPolicy, source Endpoint, destination Endpoint, port string) bool {
+	return networkPolicyDirectionAllows(items, netv1.PolicyTypeEgress, source, destination, port) &&
+		networkPolicyDirectionAllows(items, netv1.PolicyTypeIngress, destination, source, port)
+}
+
+// networkPolicyDirectionAllows reports if the policies selecting a pod for a direction allow its peer,
+// a pod no policy selects for the direction is not isolated and addresses are never selected
+func networkPolicyDirectionAllows(items []netv1.NetworkPolicy, direction netv1.PolicyType, subject Endpoint, peer Endpoint, port string) bool {
+	if !subject.IsPod() {
+		return true
+	}
+
+	isolated := false
+	for _, policy := range items {
+		if policy.Namespace != subject.Namespace || !effectivePolicyType(policy, direction) {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		if err != nil || !selector.Matches(subject.Labels) {
+			continue
+		}
+		isolated = true
+
+		if direction == netv1.PolicyTypeIngress {
+			for _, rule := range policy.Spec.Ingress {
+				if networkPolicyPortsAllow(rule.Ports, port) && networkPolicyPeersAllow(rule.From, policy.Namespace, peer) {
+					return true
+				}
+			}
+		} else {
+			for _, rule := range policy.Spec.Egress {
+				if networkPolicyPortsAllow(rule.Ports, port) && networkPolicyPeersAllow(rule.To, policy.Namespace, peer) {
+					return true
+				}
+			}
+		}
+	}
+	return !isolated
+}
+
+// effectivePolicyType reports if a policy applies to a direction, without policy types
+// it applies to ingress, and to egress when it has egress rules
+func effectivePolicyType(policy netv1.NetworkPolicy, direction netv1.PolicyType) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return direction == netv1.PolicyTypeIngress || len(policy.Spec.Egress) > 0
+	}
+	for _, policyType := range policy.Spec.PolicyTypes {
+		if policyType == direction {
+			return true
+		}
+	}
+	return false
+}
+
+// networkPolicyPortsAllow reports if the ports of a rule hold a protocol/port key, no ports hold every port
+func networkPolicyPortsAllow(ports []netv1.NetworkPolicyPort, key string) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	parts := strings.SplitN(key, "/", 2)
+	for _, port := range ports {
+		protocol := corev1.ProtocolTCP
+		if port.Protocol != nil {
+			protocol = *port.Protocol
+		}
+		if string(protocol) != parts[0] {
+			continue
+		}
+		if port.Port == nil || (len(parts) == 2 && port.Port.String() == parts[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkPolicyPeersAllow reports if the peers of a rule hold an endpoint, no peers hold every endpoint
+func networkPolicyPeersAllow(peers []netv1.NetworkPolicyPeer, namespace string, e Endpoint) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if peer.IPBlock != nil {
+			for _, ip := range e.IPs {
+				if ipBlockHolds(*peer.IPBlock, ip) {
+					return true
+				}
+			}
+			continue
+		}
+		if !e.IsPod() {
+			continue
+		}
+
+		if peer.NamespaceSelector == nil {
+			if e.Namespace != namespace {
+				continue
+			}
+		} else {
+			selector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+			if err != nil || !selector.Matches(e.NamespaceLabels) {
+				continue
+			}
+		}
+		if peer.PodSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+			if err != nil || !selector.Matches(e.Labels) {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ipBlockHolds reports if an IP is in an ipBlock and none of its except blocks
+func ipBlockHolds(block netv1.IPBlock, ip string) bool {
+	if !cidrContains(block.CIDR, ip) {
+		return false
+	}
+	for _, except := range block.Except {
+		if cidrContains(except, ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyEndpoints returns the pods of the inventory, one per namespace and labels, or synthetic pods when there are none,
+// and an address at each end of every ipBlock and except block, with the selectors no synthetic pod covers
+func verifyEndpoints(items []netv1.NetworkPolicy, inventory *Inventory) ([]Endpoint, []Finding) {
+	var endpoints []Endpoint
+	var findings []Finding
+	if inventory != nil && len(inventory.Pods) > 0 {
+		seen := map[string]bool{}
+		for _, pod := range inventory.NetworkPods() {
+			endpoint := inventory.podEndpoint(pod)
+			key := endpoint.Namespace + "/" + endpoint.Labels.String()
+			if !seen[key] {
+				seen[key] = true
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+	} else {
+		endpoints, findings = syntheticPolicyEndpoints(items)
+	}
+
+	var addresses []string
+	for _, policy := range items {
+		for _, peer := range policyPeers(policy) {
+			if peer.IPBlock == nil {
+				continue
+			}
+			for _, cidr := range append([]string{peer.IPBlock.CIDR}, peer.IPBlock.Except...) {
+				addresses = append(addresses, cidrBounds(cidr)...)
+			}
+		}
+	}
+	for _, address := range uniqueSorted(addresses) {
+		endpoints = append(endpoints, Endpoint{Name: address, IPs: []string{address}})
+	}
+	return endpoints, findings
+}
+
+// syntheticPolicyEndpoints returns a pod for the labels of every pod selector in every namespace of the policies
+// and namespace selectors, and an unlabeled pod in each, namespaces no selector names are labeled to match
+// their namespace selector, selectors no labels can match are returned as findings
+func syntheticPolicyEndpoints(items []netv1.NetworkPolicy) ([]Endpoint, []Finding) {
+	var findings []Finding
+	uncovered := func(policy netv1.NetworkPolicy, selector *metav1.LabelSelector, kind string) {
+		findings = append(findings, Finding{Severity: SeverityMedium, Check: CheckUncoveredSelector,
+			Policy: PolicyReference{Namespace: policy.Namespace, Name: policy.Name}.String(), Order: -1,
+			Reason: fmt.Sprintf("no synthetic %s matches the selector %s", kind, metav1.FormatLabelSelector(selector))})
+	}
+
+	namespaces := map[string]labels.Set{}
+	addNamespace := func(name string, set labels.Set) {
+		merged := labels.Set{namespaceNameLabel: name}
+		for key, value := range set {
+			merged[key] = value
+		}
+		namespaces[name] = labels.Merge(namespaces[name], merged)
+	}
+	podLabels := map[string]labels.Set{"": {}}
+	addPods := func(policy netv1.NetworkPolicy, selector *metav1.LabelSelector) {
+		if selector == nil {
+			return
+		}
+		set, ok := syntheticLabels(selector, nil)
+		if !ok {
+			uncovered(policy, selector, "pod")
+			return
+		}
+		podLabels[set.String()] = set
+	}
+
+	for _, policy := range items {
+		addNamespace(policy.Namespace, nil)
+		addPods(policy, &policy.Spec.PodSelector)
+		for _, peer := range policyPeers(policy) {
+			addPods(policy, peer.PodSelector)
+			if peer.NamespaceSelector == nil || (len(peer.NamespaceSelector.MatchLabels) == 0 && len(peer.NamespaceSelector.MatchExpressions) == 0) {
+				continue
+			}
+			set, ok := syntheticLabels(peer.NamespaceSelector, nil)
+			name := set[namespaceNameLabel]
+			if name == "" {
+				name = "synthetic-" + strings.Trim(invalidNameCharacters.ReplaceAllString(strings.ToLower(set.String()), "-"), "-")
+			}
+			if ok {
+				// the name label of the namespace must match too
+				set, ok = syntheticLabels(peer.NamespaceSelector, labels.Set{namespaceNameLabel: name})
+			}
+			if !ok {
+				uncovered(policy, peer.NamespaceSelector, "namespace")
+				continue
+			}
+			addNamespace(name, set)
+		}
+	}
+
+	var names, sets []string
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	for key := range podLabels {
+		sets = append(sets, key)
+	}
+	sort.Strings(names)
+	sort.Strings(sets)
+
+	var endpoints []Endpoint
+	for _, namespace := range names {
+		for _, key := range sets {
+			name := key
+			if name == "" {
+				name = "*"
+			}
+			endpoints = append(endpoints, Endpoint{Namespace: namespace, Name: name, NamespaceLabels: namespaces[namespace], Labels: podLabels[key]})
+		}
+	}
+	return endpoints, findings
+}
+
+// syntheticLabels returns labels a selector matches, adding to fixed labels its match labels, the first value of
+// its In expressions and a value for its Exists expressions, and reports if the selector matches them
+func syntheticLabels(selector *metav1.LabelSelector, fixed labels.Set) (labels.Set, bool) {
+	set := labels.Merge(labels.Set{}, fixed)
+	for key, value := range selector.MatchLabels {
+		if _, ok := fixed[key]; !ok {
+			set[key] = value
+		}
+	}
+	for _, expression := range selector.MatchExpressions {
+		if set.Has(expression.Key) {
+			continue
+		}
+		switch expression.Operator {
+		case metav1.LabelSelectorOpIn:
+			if len(expression.Values) > 0 {
+				set[expression.Key] = expression.Values[0]
+			}
+		case metav1.LabelSelectorOpExists:
+			set[expression.Key] = "synthetic"
+		}
+	}
+
+	matcher, err := metav1.LabelSelectorAsSelector(selector)
+	return set, err == nil && matcher.Matches(set)
+}
+
+// policyPeers returns the peers of every rule of a policy
+func policyPeers(policy netv1.NetworkPolicy) []netv1.NetworkPolicyPeer {
+	var peers []netv1.NetworkPolicyPeer
+	for _, rule := range policy.Spec.Ingress {
+		peers = append(peers, rule.From...)
+	}
+	for _, rule := range policy.Spec.Egress {
+		peers = append(peers, rule.To...)
+	}
+	return peers
+}
+
+// cidrBounds returns the first and last addresses of a CIDR
+func cidrBounds(cidr string) []string {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+	last := make(net.IP, len(network.IP))
+	for i := range network.IP {
+		last[i] = network.IP[i] | ^network.Mask[i]
+	}
+	return []string{network.IP.String(), last.String()}
+}
+
+// verifyPorts returns the protocol/port keys of the policies, a protocol without port stands for its unlisted port,
+// and the unlisted TCP port
+func verifyPorts(items []netv1.NetworkPolicy) []string {
+	keys := []string{string(corev1.ProtocolTCP) + "/" + verifyUnlistedPort}
+	for _, policy := range items {
+		var ports []netv1.NetworkPolicyPort
+		for _, rule := range policy.Spec.Ingress {
+			ports = append(ports, rule.Ports...)
+		}
+		for _, rule := range policy.Spec.Egress {
+			ports = append(ports, rule.Ports...)
+		}
+		for _, port := range ports {
+			key := portKey(port)
+			if port.Port == nil {
+				key += "/" + verifyUnlistedPort
+			}
+			keys = append(keys, key)
+		}
+	}
+	return uniqueSorted(keys)
+}
+
+// PrintVerification writes the outcome of a round trip check as JSON or as a summary and tables
+func PrintVerification(w io.Writer, result Verification, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(result)
+	case "table":
+		fmt.Fprintf(w, "%d connections checked between %d endpoints on %d ports, %d counterexamples\n",
+			result.Checked, result.Endpoints, result.Ports, len(result.Counterexamples))
+		if len(result.Counterexamples) > 0 {
+			newTablePrinter(w).Print(result.Counterexamples)
+		}
+		if len(result.Unexpressed) > 0 {
+			fmt.Fprintln(w, "Rules the regenerated policies cannot express:")
+			newTablePrinter(w).Print(result.Unexpressed)
+		}
+		if len(result.Uncovered) > 0 {
+			fmt.Fprintln(w, "Selectors no synthetic pod covers:")
+			newTablePrinter(w).Print(result.Uncovered)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown verification format %q, use json or table", format)
+}
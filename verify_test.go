@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestVerifyRoundTrip runs the round trip check over the fixtures, with their pods and with synthetic pods
+func TestVerifyRoundTrip(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		inventory bool
+		endpoints int
+		uncovered int
+	}{
+		{fixture: "v.yaml", inventory: true, endpoints: 9},
+		{fixture: "v.yaml", endpoints: 18},
+		// the namespaces and pods of the expressions, and the selector no labels match
+		{fixture: "expressions.yaml", endpoints: 12, uncovered: 1},
+	}
+
+	for _, test := range tests {
+		name := test.fixture
+		if test.inventory {
+			name += "/inventory"
+		}
+		t.Run(name, func(t *testing.T) {
+			manifests, err := LoadManifests([]string{filepath.Join("testdata", test.fixture)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var inventory *Inventory
+			if test.inventory {
+				inventory = &manifests.Inventory
+			}
+
+			result := VerifyRoundTrip(manifests.Policies, inventory)
+			if result.Endpoints != test.endpoints {
+				t.Errorf("got %d endpoints, want %d", result.Endpoints, test.endpoints)
+			}
+			for _, example := range result.Counterexamples {
+				t.Errorf("counterexample %+v", example)
+			}
+			if len(result.Uncovered) != test.uncovered {
+				t.Errorf("got uncovered selectors %+v, want %d", result.Uncovered, test.uncovered)
+			}
+		})
+	}
+}
+
+// TestVerifyRoundTripKeepsTranslation checks the round trip check leaves a translation in progress untouched
+func TestVerifyRoundTripKeepsTranslation(t *testing.T) {
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", "v.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	translator := NewTranslator(0)
+	first := translator.TranslatePolicies(manifests.Policies)
+	count := 0
+	for _, policy := range first {
+		count += len(policy.Rules)
+	}
+
+	VerifyRoundTrip(manifests.Policies, nil)
+
+	translated := translator.TranslatePolicies(manifests.Policies[:1])
+	if len(translated) != len(first)+1 {
+		t.Fatalf("got %d policies, want %d", len(translated), len(first)+1)
+	}
+	if got := translated[len(first)].Rules[0].Order; got != count {
+		t.Errorf("got order %d for the next rule, want %d", got, count)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	netv1 "k8s.io/api/networking/v1"
+)
+
+// Flow is the traffic a change opens or breaks from a workload to another
+type Flow struct {
+	From  string   `json:"from" header:"From"`
+	To    string   `json:"to" header:"To"`
+	Ports []string `json:"ports" header:"Ports"`
+}
+
+// IsolatedPod is a pod a change isolates for ingress, egress or both, where no policy selected it before
+type IsolatedPod struct {
+	Namespace string `json:"namespace" header:"Namespace"`
+	Pod       string `json:"pod" header:"Pod"`
+	Workload  string `json:"workload" header:"Workload"`
+	Isolated  string `json:"isolated" header:"Isolated"`
+}
+
+// WhatIf is the outcome of proposed policy changes, nothing is applied
+type WhatIf struct {
+	Rules    []RuleChange     `json:"rules"`
+	Opened   []Flow           `json:"opened"`
+	Broken   []Flow           `json:"broken"`
+	Isolated []IsolatedPod    `json:"isolated"`
+	Policies []FirewallPolicy `json:"policies"`
+}
+
+// ProposePolicies returns the current policies with the deleted ones, given as namespace/name, removed
+// and the proposed ones translated, replacing the current policies of the same name
+// the proposed rules are ordered after the current ones
+func ProposePolicies(current []FirewallPolicy, proposed []netv1.NetworkPolicy, deleted []string) ([]FirewallPolicy, error) {
+	names := map[string]bool{}
+	for _, policy := range current {
+		names[policy.Reference().String()] = true
+	}
+	removed := map[string]bool{}
+	for _, name := range deleted {
+		if !names[name] {
+			return nil, fmt.Errorf("policy to delete %s not found, use namespace/name", name)
+		}
+		removed[name] = true
+	}
+
+	next := 0
+	for _, policy := range current {
+		for _, rule := range policy.Rules {
+			if rule.Order >= next {
+				next = rule.Order + 1
+			}
+		}
+	}
+
+	translator := NewTranslator(next)
+	for _, policy := range proposed {
+		removed[policy.Namespace+"/"+policy.Name] = true
+		translator.PolicyRulesTranslator(policy)
+	}
+	translated := translator.Policies
+
+	var result []FirewallPolicy
+	for _, policy := range current {
+		if !removed[policy.Reference().String()] {
+			result = append(result, policy)
+		}
+	}
+	return append(result, translated...), nil
+}
+
+// SimulatePolicies compares the current policies with the proposed ones against the same inventory:
+// the rules changed, the flows between workloads opened and broken, and the pods newly isolated
+func SimulatePolicies(current []FirewallPolicy, proposed []FirewallPolicy, inventory *Inventory) WhatIf {
+	result := WhatIf{
+		Rules:    DiffPolicies(current, inventory, proposed, inventory).Rules,
+		Opened:   []Flow{},
+		Broken:   []Flow{},
+		Isolated: []IsolatedPod{},
+		Policies: proposed,
+	}
+
+	changes := diffConnectivity(
+		ConnectivityMatrix(current, inventory, MatrixWorkload),
+		ConnectivityMatrix(proposed, inventory, MatrixWorkload))
+	for _, change := range changes {
+		if len(change.Gained) > 0 {
+			result.Opened = append(result.Opened, Flow{From: change.From, To: change.To, Ports: change.Gained})
+		}
+		if len(change.Lost) > 0 {
+			result.Broken = append(result.Broken, Flow{From: change.From, To: change.To, Ports: change.Lost})
+		}
+	}
+
+	if inventory != nil {
+		result.Isolated = newlyIsolated(AuditUnprotected(current, inventory, nil), AuditUnprotected(proposed, inventory, nil))
+	}
+	return result
+}
+
+// newlyIsolated returns the pods unprotected in a direction before and no longer after
+func newlyIsolated(before []UnprotectedNamespace, after []UnprotectedNamespace) []IsolatedPod {
+	remaining := map[string]string{}
+	for _, namespace := range after {
+		for _, pod := range namespace.Pods {
+			remaining[pod.Namespace+"/"+pod.Pod] = pod.Unprotected
+		}
+	}
+
+	isolated := []IsolatedPod{}
+	for _, namespace := range before {
+		for _, pod := range namespace.Pods {
+			var directions []string
+			for _, direction := range strings.Split(pod.Unprotected, ",") {
+				if !strings.Contains(remaining[pod.Namespace+"/"+pod.Pod], direction) {
+					directions = append(directions, direction)
+				}
+			}
+			if len(directions) > 0 {
+				isolated = append(isolated, IsolatedPod{Namespace: pod.Namespace, Pod: pod.Pod, Workload: pod.Workload, Isolated: strings.Join(directions, ",")})
+			}
+		}
+	}
+	return isolated
+}
+
+// PrintWhatIf writes the outcome of proposed changes as JSON, with the resulting policies, or as tables
+func PrintWhatIf(w io.Writer, result WhatIf, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(result)
+	case "table":
+		fmt.Fprintln(w, "Rules:")
+		newTablePrinter(w).Print(result.Rules)
+		fmt.Fprintln(w, "Flows opened:")
+		newTablePrinter(w).Print(result.Opened)
+		fmt.Fprintln(w, "Flows broken:")
+		newTablePrinter(w).Print(result.Broken)
+		fmt.Fprintln(w, "Pods newly isolated:")
+		newTablePrinter(w).Print(result.Isolated)
+		return nil
+	}
+	return fmt.Errorf("unknown what-if format %q, use json or table", format)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestProposePolicies(t *testing.T) {
+	current, _ := loadFixture(t, "v.yaml", nil)
+	denyAll := netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "deny-all"},
+		Spec:       netv1.NetworkPolicySpec{PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress}},
+	}
+	web := netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"},
+		Spec: netv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		proposed []netv1.NetworkPolicy
+		deleted  []string
+		// want are the policies and the orders of their rules
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "nothing proposed",
+			want: []string{"shop/web 0 1 2 3", "db/pg 4 5 6 7"},
+		},
+		{
+			name:     "new policy ordered after the current ones",
+			proposed: []netv1.NetworkPolicy{denyAll},
+			want:     []string{"shop/web 0 1 2 3", "db/pg 4 5 6 7", "edge/deny-all 8"},
+		},
+		{
+			name:     "policy of the same name replaced",
+			proposed: []netv1.NetworkPolicy{web},
+			want:     []string{"db/pg 4 5 6 7", "shop/web 8"},
+		},
+		{
+			name:    "policy deleted",
+			deleted: []string{"db/pg"},
+			want:    []string{"shop/web 0 1 2 3"},
+		},
+		{
+			name:    "unknown policy to delete",
+			deleted: []string{"pg"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proposed, err := ProposePolicies(current, test.proposed, test.deleted)
+			if test.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, policy := range proposed {
+				line := policy.Reference().String()
+				for _, rule := range policy.Rules {
+					line += fmt.Sprintf(" %d", rule.Order)
+				}
+				got = append(got, line)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got policies %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSimulatePolicies(t *testing.T) {
+	http := intstr.FromString("http")
+	lb := netv1.NetworkPolicyPeer{
+		PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "lb"}},
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "edge"}},
+	}
+
+	tests := []struct {
+		name     string
+		proposed []netv1.NetworkPolicy
+		deleted  []string
+		opened   []string
+		broken   []string
+		isolated []string
+	}{
+		{
+			name: "nothing proposed",
+		},
+		{
+			name: "deny all ingress of a namespace",
+			proposed: []netv1.NetworkPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "deny-all"},
+				Spec:       netv1.NetworkPolicySpec{PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress}},
+			}},
+			broken:   []string{"edge/lb-1 edge/lb-1 ANY", "shop/web-0 edge/lb-1 ANY"},
+			isolated: []string{"edge/lb-1 ingress"},
+		},
+		{
+			name: "narrower replacement",
+			proposed: []netv1.NetworkPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"},
+				Spec: netv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+					PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+					Ingress:     []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{lb}, Ports: []netv1.NetworkPolicyPort{{Port: &http}}}},
+				},
+			}},
+			broken: []string{"edge/lb-1 shop/web-0 TCP/9443", "shop/web-0 shop/web-0 TCP/8080,TCP/9443"},
+		},
+		{
+			name:    "policy deleted",
+			deleted: []string{"db/pg"},
+			opened: []string{
+				"db/pg-0 db/pg-0 ANY",
+				"db/pg-0 edge/lb-1 ANY",
+				"db/pg-0 shop/web-0 TCP/8080,TCP/9443",
+				"edge/lb-1 db/pg-0 ANY",
+				"shop/web-0 db/pg-0 ANY except TCP/5432",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current, inventory := loadFixture(t, "v.yaml", nil)
+			for i := range test.proposed {
+				defaultPolicy(&test.proposed[i])
+			}
+			proposed, err := ProposePolicies(current, test.proposed, test.deleted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ResolveWorkloads(proposed, inventory)
+			ResolveNamedPorts(proposed, inventory)
+			result := SimulatePolicies(current, proposed, inventory)
+
+			flows := func(flows []Flow) []string {
+				var lines []string
+				for _, flow := range flows {
+					lines = append(lines, fmt.Sprintf("%s %s %s", flow.From, flow.To, strings.Join(flow.Ports, ",")))
+				}
+				return lines
+			}
+			if got := flows(result.Opened); !reflect.DeepEqual(got, test.opened) {
+				t.Errorf("got opened flows\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.opened, "\n"))
+			}
+			if got := flows(result.Broken); !reflect.DeepEqual(got, test.broken) {
+				t.Errorf("got broken flows\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.broken, "\n"))
+			}
+			var isolated []string
+			for _, pod := range result.Isolated {
+				isolated = append(isolated, pod.Namespace+"/"+pod.Pod+" "+pod.Isolated)
+			}
+			if !reflect.DeepEqual(isolated, test.isolated) {
+				t.Errorf("got isolated pods %q, want %q", isolated, test.isolated)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// externalZone holds the addresses no zone defines
+const externalZone = "external"
+
+// ZoneConfig defines the firewall zones of the cluster, namespaces no zone holds are their own zone
+type ZoneConfig struct {
+	Zones []Zone `json:"zones"`
+}
+
+// Zone groups namespaces, by name or label selector, node subnets and external CIDRs under a name
+// a namespace belongs to the first zone holding it
+type Zone struct {
+	Name              string                `json:"name"`
+	Namespaces        []string              `json:"namespaces,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	NodeSubnets       []string              `json:"nodeSubnets,omitempty"`
+	CIDRs             []string              `json:"cidrs,omitempty"`
+}
+
+// LoadZoneConfig reads a zone definition file
+func LoadZoneConfig(path string) (*ZoneConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := new(ZoneConfig)
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// ParseZoneGroups parses zone=namespace,namespace definitions into zones
+func ParseZoneGroups(definitions []string) ([]Zone, error) {
+	var zones []Zone
+	for _, definition := range definitions {
+		parts := strings.SplitN(definition, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid zone %q, use zone=namespace,namespace", definition)
+		}
+		zones = append(zones, Zone{Name: parts[0], Namespaces: strings.Split(parts[1], ",")})
+	}
+	return zones, nil
+}
+
+// validate checks zones have a name and valid CIDRs, and namespaces are listed by a single zone
+func (c *ZoneConfig) validate() error {
+	namespaces := map[string]string{}
+	for _, zone := range c.Zones {
+		if zone.Name == "" {
+			return fmt.Errorf("zone without name")
+		}
+		for _, namespace := range zone.Namespaces {
+			if other, ok := namespaces[namespace]; ok && other != zone.Name {
+				return fmt.Errorf("namespace %s is in zones %s and %s", namespace, other, zone.Name)
+			}
+			namespaces[namespace] = zone.Name
+		}
+		for _, cidr := range zone.cidrs() {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("zone %s: %v", zone.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// cidrs returns the node subnets and external CIDRs of a zone
+func (z Zone) cidrs() []string {
+	return append(append([]string{}, z.NodeSubnets...), z.CIDRs...)
+}
+
+// NamespaceZone returns the zone of a namespace, listed by name first, then by selector, or the namespace itself
+func (c *ZoneConfig) NamespaceZone(namespace string, namespaceLabels labels.Set) string {
+	for _, zone := range c.Zones {
+		for _, name := range zone.Namespaces {
+			if name == namespace {
+				return zone.Name
+			}
+		}
+	}
+	for _, zone := range c.Zones {
+		if zone.NamespaceSelector != nil && selectorMatches(zone.NamespaceSelector, namespaceLabels) {
+			return zone.Name
+		}
+	}
+	return namespace
+}
+
+// cidrZones returns the zones a CIDR overlaps, external when the zones do not cover it all
+func (c *ZoneConfig) cidrZones(cidr string) []string {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return []string{externalZone}
+	}
+
+	var zones []string
+	covered := false
+	for _, zone := range c.Zones {
+		for _, zoneCIDR := range zone.cidrs() {
+			_, zoneNetwork, err := net.ParseCIDR(zoneCIDR)
+			if err != nil || !(zoneNetwork.Contains(network.IP) || network.Contains(zoneNetwork.IP)) {
+				continue
+			}
+			zones = append(zones, zone.Name)
+			zoneOnes, _ := zoneNetwork.Mask.Size()
+			ones, _ := network.Mask.Size()
+			if zoneOnes <= ones {
+				covered = true
+			}
+			break
+		}
+	}
+	if !covered {
+		zones = append(zones, externalZone)
+	}
+	return uniqueSorted(zones)
+}
+
+// zoneAssigner annotates locations with zones, the zones of all namespaces and of every address are known up front
+type zoneAssigner struct {
+	config         *ZoneConfig
+	inventory      *Inventory
+	namespaces     []string
+	namespaceZones []string
+	allZones       []string
+}
+
+// AssignZones annotates the locations of every rule with their zones
+// namespaces are the ones of the inventory and of the policies, any location spans all the zones
+func AssignZones(policies []FirewallPolicy, config *ZoneConfig, inventory *Inventory) {
+	if config == nil {
+		config = new(ZoneConfig)
+	}
+	assigner := &zoneAssigner{config: config, inventory: inventory}
+
+	var namespaces []string
+	if inventory != nil {
+		for _, namespace := range inventory.Namespaces {
+			namespaces = append(namespaces, namespace.Name)
+		}
+		for _, pod := range inventory.Pods {
+			namespaces = append(namespaces, pod.Namespace)
+		}
+	}
+	for _, policy := range policies {
+		namespaces = append(namespaces, policy.Namespace)
+		for _, rule := range policy.Rules {
+			namespaces = append(namespaces, rule.From.Namespace, rule.To.Namespace)
+		}
+	}
+	assigner.namespaces = uniqueSorted(namespaces)
+
+	var zones []string
+	for _, namespace := range assigner.namespaces {
+		zones = append(zones, assigner.namespaceZone(namespace))
+	}
+	assigner.namespaceZones = uniqueSorted(zones)
+	for _, zone := range config.Zones {
+		if len(zone.cidrs()) > 0 {
+			zones = append(zones, zone.Name)
+		}
+	}
+	assigner.allZones = uniqueSorted(append(zones, externalZone))
+
+	for i := range policies {
+		for j := range policies[i].Rules {
+			rule := &policies[i].Rules[j]
+			rule.From.Zones = assigner.locationZones(rule.From)
+			rule.To.Zones = assigner.locationZones(rule.To)
+		}
+	}
+}
+
+// namespaceZone returns the zone of a namespace with its labels
+func (a *zoneAssigner) namespaceZone(namespace string) string {
+	return a.config.NamespaceZone(namespace, a.namespaceLabels(namespace))
+}
+
+// locationZones returns the zones of a location, the zones of its resolved workloads when there are some
+func (a *zoneAssigner) locationZones(location FirewallLocation) []string {
+	switch {
+	case location.Any:
+		return a.allZones
+	case location.CIDR != "":
+		return a.config.cidrZones(location.CIDR)
+	case location.DNSName != "":
+		return []string{externalZone}
+	case location.AllNamespaces && len(location.Workloads) == 0:
+		return a.namespaceZones
+	}
+
+	var zones []string
+	for _, workload := range location.Workloads {
+		zones = append(zones, a.namespaceZone(workload.Namespace))
+	}
+	if len(zones) == 0 {
+		// without workloads the location spans the zones of all the namespaces it can select
+		scope := location
+		scope.PodSelector = nil
+		for _, namespace := range a.namespaces {
+			if scope.matchesPod(namespace, a.namespaceLabels(namespace), nil) {
+				zones = append(zones, a.namespaceZone(namespace))
+			}
+		}
+	}
+	return uniqueSorted(zones)
+}
+
+// namespaceLabels returns the labels of a namespace, only its name label without inventory
+func (a *zoneAssigner) namespaceLabels(namespace string) labels.Set {
+	return namespaceLabels(a.inventory, namespace)
+}
+
+// uniqueSorted returns the sorted distinct non empty values of a list
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestLoadZoneConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		zones   []string
+		wantErr string
+	}{
+		{
+			name: "namespaces, selectors and CIDRs",
+			content: `zones:
+- name: dmz
+  namespaces: [edge]
+  namespaceSelector: {matchLabels: {tier: front}}
+- name: nodes
+  nodeSubnets: [192.168.0.0/24]
+  cidrs: [10.96.0.0/12]
+`,
+			zones: []string{"dmz", "nodes"},
+		},
+		{name: "unknown field", content: "zones:\n- name: dmz\n  namespace: [edge]\n", wantErr: "unknown field"},
+		{name: "zone without name", content: "zones:\n- namespaces: [edge]\n", wantErr: "zone without name"},
+		{name: "namespace in two zones", content: "zones:\n- name: a\n  namespaces: [edge]\n- name: b\n  namespaces: [edge]\n", wantErr: "namespace edge is in zones a and b"},
+		{name: "invalid CIDR", content: "zones:\n- name: a\n  cidrs: [10.0.0.0/33]\n", wantErr: "zone a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "zones")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "zones.yaml")
+			if err := ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadZoneConfig(path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("got error %v, want one with %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var zones []string
+			for _, zone := range config.Zones {
+				zones = append(zones, zone.Name)
+			}
+			if !reflect.DeepEqual(zones, test.zones) {
+				t.Errorf("got zones %v, want %v", zones, test.zones)
+			}
+		})
+	}
+}
+
+func TestZoneConfig(t *testing.T) {
+	config := &ZoneConfig{Zones: []Zone{
+		{Name: "front", NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "front"}}},
+		{Name: "dmz", Namespaces: []string{"edge"}, CIDRs: []string{"203.0.113.0/24"}},
+		{Name: "nodes", NodeSubnets: []string{"192.168.0.0/16"}},
+	}}
+
+	namespaces := []struct {
+		namespace string
+		labels    labels.Set
+		want      string
+	}{
+		{namespace: "edge", labels: labels.Set{"tier": "front"}, want: "dmz"},
+		{namespace: "web", labels: labels.Set{"tier": "front"}, want: "front"},
+		{namespace: "db", labels: labels.Set{"tier": "back"}, want: "db"},
+	}
+	for _, test := range namespaces {
+		if got := config.NamespaceZone(test.namespace, test.labels); got != test.want {
+			t.Errorf("namespace %s is in zone %s, want %s", test.namespace, got, test.want)
+		}
+	}
+
+	cidrs := []struct {
+		cidr string
+		want []string
+	}{
+		{cidr: "203.0.113.8/29", want: []string{"dmz"}},
+		{cidr: "192.168.0.0/16", want: []string{"nodes"}},
+		{cidr: "192.168.0.0/15", want: []string{"external", "nodes"}},
+		{cidr: "0.0.0.0/0", want: []string{"dmz", "external", "nodes"}},
+		{cidr: "198.51.100.0/24", want: []string{"external"}},
+	}
+	for _, test := range cidrs {
+		if got := config.cidrZones(test.cidr); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("CIDR %s is in zones %v, want %v", test.cidr, got, test.want)
+		}
+	}
+}
+
+func TestAssignZones(t *testing.T) {
+	manifests, err := LoadManifests([]string{filepath.Join("testdata", "v.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := TranslatePolicies(manifests.Policies)
+	ResolveWorkloads(policies, &manifests.Inventory)
+	config := &ZoneConfig{Zones: []Zone{
+		{Name: "dmz", Namespaces: []string{"edge"}},
+		{Name: "services", CIDRs: []string{"10.96.0.0/12"}},
+	}}
+	AssignZones(policies, config, &manifests.Inventory)
+
+	want := map[string]string{
+		"Ingress ALLOW {team=edge}/app=lb shop/app=web":            "dmz > shop",
+		"Ingress ALLOW 10.0.0.0/8 except 10.1.0.0/16 shop/app=web": "external,services > shop",
+		"Ingress DENY any shop/app=web":                            "db,dmz,external,services,shop > shop",
+		"Ingress ALLOW */app=web db/app=pg":                        "shop > db",
+		"Egress ALLOW db/app=pg 10.96.0.0/12":                      "db > services",
+	}
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			key := fmt.Sprintf("%s %s %s %s", rule.Direction, rule.Action, FormatLocation(rule.From), FormatLocation(rule.To))
+			expected, ok := want[key]
+			if !ok {
+				continue
+			}
+			delete(want, key)
+			if got := strings.Join(rule.From.Zones, ",") + " > " + strings.Join(rule.To.Zones, ","); got != expected {
+				t.Errorf("%s has zones %s, want %s", key, got, expected)
+			}
+		}
+	}
+	for key := range want {
+		t.Errorf("no rule %s", key)
+	}
+}